@@ -0,0 +1,96 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gwatts/plsdo/pkg/plsdo"
+	"github.com/spf13/cobra"
+)
+
+const fmtGithub = "github"
+
+// exitViolations is the exit status when violations are found, distinguishing
+// them from errors running the check, which exit with status 1.
+const exitViolations = 3
+
+var (
+	policyFile  string
+	checkFormat string
+	failOn      string
+)
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Fails if forbidden functions or methods are referenced",
+	Long: `Reads a YAML or TOML policy file listing forbidden package and pattern rules,
+and reports every violating reference.  Exits with status 3 if any violation
+is at or above the --fail-on severity, or 1 if the check could not be run.
+
+Example policy:
+
+  rules:
+    - id: no-md5
+      package: crypto/md5
+      patterns: [New, Sum]
+      message: MD5 is not permitted; use crypto/sha256
+      severity: error
+      allow: ["legacy/**"]`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		policy, err := plsdo.LoadPolicy(inDir(policyFile))
+		cobra.CheckErr(err)
+
+		var write func(io.Writer, []plsdo.Violation) error
+		switch checkFormat {
+		case "text":
+			write = plsdo.WriteViolationsText
+		case fmtJson:
+			write = plsdo.WriteViolationsJson
+		case fmtSarif:
+			write = plsdo.WriteViolationsSarif
+		case fmtGithub:
+			write = plsdo.WriteViolationsGithub
+		default:
+			fmt.Fprintln(os.Stderr, "invalid mode")
+			os.Exit(1)
+		}
+		threshold, ok := severityRank[failOn]
+		if !ok {
+			fmt.Fprintln(os.Stderr, "invalid --fail-on severity")
+			os.Exit(1)
+		}
+
+		m := newMatcher(cmd)
+		violations, err := m.Check(policy)
+		m.Close()
+		cobra.CheckErr(err)
+		cobra.CheckErr(write(os.Stdout, violations))
+
+		for _, v := range violations {
+			if severityRank[v.Severity] >= threshold {
+				os.Exit(exitViolations)
+			}
+		}
+	},
+}
+
+var severityRank = map[string]int{
+	plsdo.LevelNote:    1,
+	plsdo.LevelWarning: 2,
+	plsdo.LevelError:   3,
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+
+	checkCmd.Flags().StringVarP(&policyFile, "policy", "p", ".plsdo.yaml", "Policy file (YAML, or TOML with a .toml extension)")
+	checkCmd.Flags().StringVarP(&checkFormat, "fmt", "f", "text", "Output format: text, json, sarif or github")
+	checkCmd.Flags().StringVar(&failOn, "fail-on", plsdo.LevelError, "Minimum violation severity that causes exit status 3: error, warning or note")
+	addQueryFlags(checkCmd)
+}
@@ -0,0 +1,49 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/gwatts/plsdo/pkg/plsdo"
+	"github.com/spf13/cobra"
+)
+
+var diffFormat string
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <old.json> <new.json>",
+	Short: "Compares two sets of references written by refs --fmt json",
+	Long: `Reports references that were added, removed or moved between two snapshots
+written by refs --fmt json or jsonl.
+
+References are compared by a fingerprint of the calling package, enclosing
+function, referenced symbol and normalized source, so unrelated edits that
+shift line numbers are reported as moves rather than additions.  Exits with
+status 1 if any references were added.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		oldRecs, err := plsdo.LoadRecords(inDir(args[0]))
+		cobra.CheckErr(err)
+		newRecs, err := plsdo.LoadRecords(inDir(args[1]))
+		cobra.CheckErr(err)
+
+		d := plsdo.DiffRecords(oldRecs, newRecs)
+		if diffFormat == fmtJson {
+			cobra.CheckErr(d.WriteJson(os.Stdout))
+		} else {
+			cobra.CheckErr(d.WriteText(os.Stdout))
+		}
+		if len(d.Added) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVarP(&diffFormat, "fmt", "f", "text", "Output format: text or json")
+}
@@ -0,0 +1,129 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gwatts/plsdo/pkg/plsdo"
+	"github.com/spf13/cobra"
+)
+
+var queryFormat string
+
+// defsCmd represents the defs command
+var defsCmd = &cobra.Command{
+	Use:   "defs <package[,package...]> <pattern> [pattern...]",
+	Short: "Lists the functions and methods matching patterns",
+	Long: `Lists the definitions that refs would find references to, for checking
+patterns before running a larger query.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var defs []plsdo.Definition
+		var err error
+		if client := remoteClient(); client != nil {
+			defs, err = client.Defs(newQuery(cmd, args))
+		} else {
+			m := newMatcher(cmd)
+			defer m.Close()
+			defs, err = m.FindDefinitions(args[0], args[1:]...)
+		}
+		cobra.CheckErr(err)
+		cobra.CheckErr(writeQueryResult(os.Stdout, defs, func(b *strings.Builder) {
+			for _, def := range defs {
+				fmt.Fprintf(b, "%s:%d:%d: %s.%s\n", def.File, def.Line, def.Column, def.Package, def.Symbol)
+			}
+		}))
+	},
+}
+
+// implsCmd represents the impls command
+var implsCmd = &cobra.Command{
+	Use:   "impls <package[,package...]> <pattern> [pattern...]",
+	Short: "Lists the implementations of interface methods",
+	Long: `Lists the implementations of the interface methods matching the patterns,
+eg. "plsdo impls io Writer.Write", and the interface methods implemented by
+matching concrete methods.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var impls []plsdo.Implementation
+		var err error
+		if client := remoteClient(); client != nil {
+			impls, err = client.Impls(newQuery(cmd, args))
+		} else {
+			m := newMatcher(cmd)
+			defer m.Close()
+			impls, err = m.FindImplementations(args[0], args[1:]...)
+		}
+		cobra.CheckErr(err)
+		cobra.CheckErr(writeQueryResult(os.Stdout, impls, func(b *strings.Builder) {
+			for _, impl := range impls {
+				def := impl.Definition
+				fmt.Fprintf(b, "%s:%d:%d: %s.%s\n", def.File, def.Line, def.Column, def.Package, def.Symbol)
+				for _, loc := range impl.Implementations {
+					fmt.Fprintf(b, "\t%s:%d:%d\n", loc.File, loc.Line, loc.Column)
+				}
+			}
+		}))
+	},
+}
+
+// symbolsCmd represents the symbols command
+var symbolsCmd = &cobra.Command{
+	Use:   "symbols <query>",
+	Short: "Searches for declarations in the workspace",
+	Long: `Lists the functions, types, methods, variables and constants in the workspace
+matching a fuzzy query, eg. "Logger.Info".`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var symbols []plsdo.Symbol
+		var err error
+		if client := remoteClient(); client != nil {
+			symbols, err = client.Symbols(args[0])
+		} else {
+			m := newMatcher(cmd)
+			defer m.Close()
+			symbols, err = m.FindSymbols(args[0])
+		}
+		cobra.CheckErr(err)
+		cobra.CheckErr(writeQueryResult(os.Stdout, symbols, func(b *strings.Builder) {
+			for _, sym := range symbols {
+				loc := sym.Location
+				fmt.Fprintf(b, "%s:%d:%d: %s %s (%s)\n", loc.File, loc.Line, loc.Column, sym.Kind, sym.Name, sym.Package)
+			}
+		}))
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{defsCmd, implsCmd, symbolsCmd} {
+		rootCmd.AddCommand(cmd)
+		cmd.Flags().StringVarP(&queryFormat, "fmt", "f", "text", "Output format: text or json")
+		addBuildFlags(cmd)
+		addRemoteFlag(cmd)
+	}
+	for _, cmd := range []*cobra.Command{defsCmd, implsCmd} {
+		cmd.Flags().BoolVar(&unexported, "unexported", false, "Include unexported functions and methods (default true for packages in the current module)")
+	}
+}
+
+// writeQueryResult writes v as JSON if selected by --fmt, or the text written by text.
+func writeQueryResult(w io.Writer, v any, text func(b *strings.Builder)) error {
+	switch queryFormat {
+	case fmtJson:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "text":
+		var b strings.Builder
+		text(&b)
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	return fmt.Errorf("unknown format %q; available formats: text, json", queryFormat)
+}
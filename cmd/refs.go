@@ -4,62 +4,318 @@ Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
 package cmd
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/gwatts/plsdo/pkg/ast"
+	"github.com/gwatts/plsdo/pkg/cache"
+	"github.com/gwatts/plsdo/pkg/git"
 	"github.com/gwatts/plsdo/pkg/plsdo"
+	"github.com/gwatts/plsdo/pkg/tui"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 const (
-	fmtJson   = "json"
-	fmtCsv    = "csv"
-	fmtPretty = "print"
+	fmtJson  = "json"
+	fmtJsonl = "jsonl"
+	fmtSarif = "sarif"
 )
 
 var (
-	format string
-	style  string
-	debug  bool
+	format      string
+	style       string
+	debug       bool
+	unexported  bool
+	instances   []string
+	build       ast.BuildConfig
+	filter      plsdo.Filter
+	where       []string
+	statements  bool
+	callArgs    bool
+	ctxBefore   int
+	ctxAfter    int
+	ctxBoth     int
+	tmplText    string
+	tmplAll     bool
+	summary     bool
+	colorMode   string
+	interactive bool
+	baseline    string
+	since       string
+	diffOnly    bool
+	blame       bool
+	noCache     bool
+	queriesFile string
+	watchMode   bool
+	watchPeriod time.Duration
 )
 
 // refsCmd represents the refs command
 var refsCmd = &cobra.Command{
-	Use:   "refs <package> <pattern> [pattern...]",
+	Use:   "refs <package[,package...]> <pattern> [pattern...]",
 	Short: "Finds and prints references to specific function or method",
-	Long:  `Accepts one or more patterns; can be a function name, or a type.method spec`,
-	Args:  cobra.MinimumNArgs(2),
+	Long: `Accepts one or more patterns; can be a function name, or a type.method spec.
+
+The package may be a comma-separated list of import paths or go list
+patterns such as ./... or example.com/lib/...
+
+Alternatively --queries reads many labelled queries from a YAML or TOML file,
+running them in a single gopls session.`,
+	Args: queryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		// find specified method locations
 
-		m, err := plsdo.NewMatcher()
-		if debug {
-			m.DebugWriter = os.Stderr
+		formatter := newFormatter()
+		depth, err := plsdo.DetectColor(colorMode, os.Stdout)
+		cobra.CheckErr(err)
+		if interactive && !term.IsTerminal(int(os.Stdout.Fd())) {
+			cobra.CheckErr("--interactive requires a terminal")
+		}
+		if interactive && watchMode {
+			cobra.CheckErr("--interactive and --watch cannot be used together")
+		}
+		if watchPeriod <= 0 {
+			cobra.CheckErr("--watch-interval must be positive")
+		}
+		if client := remoteClient(); client != nil {
+			if interactive || watchMode {
+				cobra.CheckErr("--interactive and --watch cannot be used with --remote")
+			}
+			req := newRefsRequest(cmd, args)
+			req.CallArgs = callArgs
+			req.Format, req.Template, req.TemplateAll = format, tmplText, tmplAll
+			req.Style, req.Color = style, depth
+			if summary {
+				req.Summary, req.SummaryJson = &statsOpts, format == fmtJson
+			}
+			count, err := client.Refs(req, os.Stdout)
+			cobra.CheckErr(err)
+			if baseline != "" && !summary && count > 0 {
+				os.Exit(1)
+			}
+			return
 		}
+
+		m := newMatcher(cmd)
 		defer m.Close()
-		cobra.CheckErr(err)
+		m.CallArgs = callArgs
+		m.Statements = statements
+		m.Blame = blame
+		m.ContextBefore, m.ContextAfter = contextLines(cmd)
 
-		pkgPath, patterns := args[0], args[1:]
-		cobra.CheckErr(m.FindFuncReferences(pkgPath, patterns...))
-
-		switch format {
-		case fmtJson:
-			cobra.CheckErr(m.Json(os.Stdout))
-		case fmtCsv:
-			cobra.CheckErr(m.Csv(os.Stdout))
-		case fmtPretty:
-			m.PrettyPrint(os.Stdout, style)
-		default:
-			fmt.Fprintln(os.Stderr, "invalid mode")
+		queries := loadQueries(args)
+		var baselineRecords []plsdo.Record
+		if baseline != "" {
+			baselineRecords, err = plsdo.LoadRecords(inDir(baseline))
+			cobra.CheckErr(err)
+		}
+		find := func() error {
+			if err := m.FindQueries(queries...); err != nil {
+				return err
+			}
+			if baseline != "" {
+				m.ExcludeBaseline(baselineRecords)
+			}
+			return nil
+		}
+		render := func(w io.Writer) error {
+			if summary {
+				return writeStats(w, m, format == fmtJson)
+			}
+			return formatter.Format(w, m, plsdo.FormatOptions{Style: style, Color: depth})
+		}
+
+		if watchMode {
+			cobra.CheckErr(watchRefs(m, find, render))
+			return
+		}
+		cobra.CheckErr(find())
+		if interactive {
+			cobra.CheckErr(tui.Run(m.Records(), tui.Options{Style: style, Color: depth}))
+			return
+		}
+		cobra.CheckErr(render(os.Stdout))
+		if baseline != "" && !summary && len(m.Records()) > 0 {
 			os.Exit(1)
 		}
 	},
 }
 
+// newFormatter returns the formatter selected by the --fmt or --template flags.
+func newFormatter() plsdo.Formatter {
+	if tmplText != "" {
+		if filename, ok := strings.CutPrefix(tmplText, "@"); ok {
+			data, err := os.ReadFile(inDir(filename))
+			cobra.CheckErr(err)
+			tmplText = string(data)
+		}
+		f, err := plsdo.NewTemplateFormatter(tmplText, tmplAll)
+		cobra.CheckErr(err)
+		return f
+	}
+	f, ok := plsdo.LookupFormatter(format)
+	if !ok {
+		cobra.CheckErr(fmt.Errorf("unknown format %q; available formats: %s", format, strings.Join(plsdo.Formats(), ", ")))
+	}
+	return f
+}
+
 func init() {
 	rootCmd.AddCommand(refsCmd)
 
-	refsCmd.Flags().StringVarP(&format, "fmt", "f", "print", "Output format")
+	refsCmd.Flags().StringVarP(&format, "fmt", "f", "print", "Output format: "+strings.Join(plsdo.Formats(), ", "))
 	refsCmd.Flags().StringVarP(&style, "style", "s", "github-dark", "Output style")
-	refsCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Emit debug information to stderr")
+	refsCmd.Flags().StringVar(&colorMode, "color", plsdo.ColorAuto, "Color terminal output: auto, always or never (auto honors NO_COLOR and FORCE_COLOR)")
+	refsCmd.Flags().BoolVar(&statements, "stmt", false, "Show the enclosing statement rather than the call")
+	refsCmd.Flags().BoolVar(&callArgs, "args", false, "Include the source, kind and type of each call argument in json, jsonl and template output; requires type checking each package (implied by --where)")
+	refsCmd.Flags().IntVarP(&ctxBefore, "before", "B", 0, "Show this many lines of context before each reference")
+	refsCmd.Flags().IntVarP(&ctxAfter, "after", "A", 0, "Show this many lines of context after each reference")
+	refsCmd.Flags().IntVarP(&ctxBoth, "context", "C", 0, "Show this many lines of context before and after each reference")
+	refsCmd.Flags().StringVarP(&tmplText, "template", "t", "", "Render each reference using a Go text/template, or @file to read the template from a file; overrides --fmt")
+	refsCmd.Flags().BoolVar(&tmplAll, "template-all", false, "Execute the template once with the list of all references")
+	refsCmd.Flags().BoolVar(&blame, "blame", false, "Include the commit, author and date that last modified each reference in json, jsonl and csv output")
+	refsCmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "Browse references interactively, opening the selected reference in $EDITOR")
+	refsCmd.Flags().StringVar(&baseline, "baseline", "", "Only report references not present in this file written by --fmt json, exiting with status 1 if any are found")
+	refsCmd.Flags().BoolVar(&summary, "summary", false, "Output reference counts rather than the references themselves, as a table or with --fmt json")
+	refsCmd.Flags().BoolVar(&watchMode, "watch", false, "Keep running, repeating the query and redisplaying the results whenever .go files change")
+	refsCmd.Flags().DurationVar(&watchPeriod, "watch-interval", 500*time.Millisecond, "How often to check for changed files with --watch")
+	addStatsFlags(refsCmd)
+	addQueryFlags(refsCmd)
+	addQueriesFlag(refsCmd)
+	addRemoteFlag(refsCmd)
+}
+
+// addQueryFlags adds the flags shared by commands that query references.
+func addQueryFlags(cmd *cobra.Command) {
+	addBuildFlags(cmd)
+	cmd.Flags().BoolVar(&unexported, "unexported", false, "Include unexported functions and methods (default true for packages in the current module)")
+	cmd.Flags().StringArrayVar(&filter.Include, "include", nil, "Only include references in files matching this glob (repeatable)")
+	cmd.Flags().StringArrayVar(&filter.Exclude, "exclude", nil, "Exclude references in files matching this glob, eg. '**/mocks/**' or '*_gen.go' (repeatable)")
+	cmd.Flags().StringArrayVar(&filter.In, "in", nil, "Only include references within functions matching this pattern, eg. 'Server.*' (repeatable)")
+	cmd.Flags().StringArrayVar(&filter.Modules, "module", nil, "Only include references within modules whose path matches this glob, eg. 'example.com/app*' (repeatable)")
+	cmd.Flags().BoolVar(&filter.ExcludeDecl, "no-decl", false, "Exclude references that are the declaration itself")
+	cmd.Flags().StringArrayVar(&where, "where", nil, "Only include calls whose arguments match a condition, eg. 'arg[0].kind=literal' or 'args contains zap.Error' (repeatable)")
+	cmd.Flags().StringVar(&since, "since", "", "Only include references on lines added or modified since the merge base of this git revision and HEAD, eg. origin/main")
+	cmd.Flags().BoolVar(&diffOnly, "diff-only", false, "Only include references on lines with uncommitted changes")
+	cmd.Flags().StringArrayVar(&instances, "instance", nil, "Only include references to generics instantiated as specified, eg. 'Cache[string, *User]'; a type argument of * matches any type (repeatable)")
+}
+
+// addQueriesFlag adds the --queries flag, for commands whose arguments are checked
+// by queryArgs and loaded by loadQueries.
+func addQueriesFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&queriesFile, "queries", "", "Run the labelled queries listed in a YAML or TOML file, rather than a package and patterns")
+}
+
+// queryArgs requires a package and at least one pattern, unless --queries is set.
+func queryArgs(cmd *cobra.Command, args []string) error {
+	if queriesFile != "" {
+		if len(args) > 0 {
+			return errors.New("a package and patterns cannot be given with --queries")
+		}
+		return nil
+	}
+	return cobra.MinimumNArgs(2)(cmd, args)
+}
+
+// loadQueries returns the queries given by the arguments or the --queries file.
+func loadQueries(args []string) []plsdo.Query {
+	if queriesFile == "" {
+		return []plsdo.Query{{Package: args[0], Patterns: args[1:]}}
+	}
+	queries, err := plsdo.LoadQueries(inDir(queriesFile))
+	cobra.CheckErr(err)
+	return queries
+}
+
+// addBuildFlags adds the flags configuring the packages loaded and the gopls session.
+func addBuildFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&debug, "debug", "d", false, "Emit debug information to stderr")
+	cmd.Flags().BoolVar(&build.Tests, "tests", false, "Also match functions and methods defined in test files; references in test files are always reported")
+	cmd.Flags().StringVar(&build.GOOS, "goos", "", "Target operating system used to select files (default host)")
+	cmd.Flags().StringVar(&build.GOARCH, "goarch", "", "Target architecture used to select files (default host)")
+	cmd.Flags().StringSliceVar(&build.Tags, "tags", nil, "Comma-separated list of additional build tags")
+	cmd.Flags().BoolVar(&noCache, "no-cache", false, "Do not read or write cached definitions and references")
+}
+
+// newMatcher creates a Matcher configured from the query flags, exiting on error.
+func newMatcher(cmd *cobra.Command) *plsdo.Matcher {
+	m, err := plsdo.NewMatcher(plsdo.WithBuildConfig(build), plsdo.WithDir(dir))
+	cobra.CheckErr(err)
+	if debug {
+		m.DebugWriter = os.Stderr
+	}
+	m.Visibility = queryVisibility(cmd)
+	m.Instances = instances
+	m.Filter = filter
+	if !noCache {
+		// the cache only speeds things up, so failing to open it is not fatal
+		cacheDir, err := cache.Dir()
+		if err == nil {
+			m.Cache, err = cache.Open(cacheDir, m.Workspace().Root)
+		}
+		if err != nil && debug {
+			fmt.Fprintf(os.Stderr, "cache disabled: %v\n", err)
+		}
+	}
+	if cmd.Flags().Lookup("since") == nil {
+		// commands without query flags, such as serve
+		return m
+	}
+	changes, err := queryChanges()
+	if err != nil {
+		m.Close()
+		cobra.CheckErr(err)
+	}
+	m.Changes = changes
+	for _, expr := range where {
+		cond, err := plsdo.ParseCondition(expr)
+		if err != nil {
+			m.Close()
+			cobra.CheckErr(err)
+		}
+		m.Where = append(m.Where, cond)
+	}
+	return m
+}
+
+// queryVisibility returns the visibility selected by the --unexported flag.
+func queryVisibility(cmd *cobra.Command) ast.Visibility {
+	if f := cmd.Flags().Lookup("unexported"); f == nil || !f.Changed {
+		return ast.VisibilityAuto
+	}
+	if unexported {
+		return ast.VisibilityAll
+	}
+	return ast.VisibilityExported
+}
+
+// queryChanges returns the lines selected by the --since or --diff-only flags,
+// or nil if neither is set.
+func queryChanges() (git.Changes, error) {
+	if since == "" && !diffOnly {
+		return nil, nil
+	}
+	if since != "" && diffOnly {
+		return nil, errors.New("--since and --diff-only cannot be used together")
+	}
+	return git.ChangedSince(workDir(), cmp.Or(since, "HEAD"))
+}
+
+// contextLines returns the number of context lines selected by the --before,
+// --after and --context flags.
+func contextLines(cmd *cobra.Command) (before, after int) {
+	before, after = ctxBoth, ctxBoth
+	if cmd.Flags().Changed("before") {
+		before = ctxBefore
+	}
+	if cmd.Flags().Changed("after") {
+		after = ctxAfter
+	}
+	return before, after
 }
@@ -0,0 +1,64 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gwatts/plsdo/pkg/ast"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rewriteRule  string
+	rewriteWrite bool
+)
+
+// rewriteCmd represents the rewrite command
+var rewriteCmd = &cobra.Command{
+	Use:   "rewrite -r <rule> <package[,package...]> <pattern> [pattern...]",
+	Short: "Rewrites calls to specific functions or methods",
+	Long: `Finds references in the same way as refs, then applies a structural
+rewrite rule to each matched call and prints a diff, or updates the files with -w.
+
+Rules take the form 'pattern -> replacement', where $name matches any expression
+and $... matches any remaining call arguments, eg.
+
+  plsdo rewrite -r '$recv.Info($msg, $...) -> $recv.Infow($msg, $...)' go.uber.org/zap Logger.Info`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		rule, err := ast.ParseRewrite(rewriteRule)
+		cobra.CheckErr(err)
+
+		m := newMatcher(cmd)
+		defer m.Close()
+
+		pkgPath, patterns := args[0], args[1:]
+		cobra.CheckErr(m.FindFuncReferences(pkgPath, patterns...))
+
+		changes, skips, err := m.Rewrite(rule)
+		cobra.CheckErr(err)
+		for _, skip := range skips {
+			fmt.Fprintf(os.Stderr, "skipped %s:%d:%d: %s\n", skip.File, skip.Line, skip.Column, skip.Reason)
+		}
+		for _, change := range changes {
+			if rewriteWrite {
+				cobra.CheckErr(change.Write())
+				fmt.Fprintf(os.Stderr, "rewrote %d call(s) in %s\n", change.Count, change.File)
+			} else {
+				fmt.Print(change.Diff())
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rewriteCmd)
+
+	rewriteCmd.Flags().StringVarP(&rewriteRule, "rule", "r", "", "Rewrite rule, eg. '$recv.Info($msg, $...) -> $recv.Infow($msg, $...)'")
+	rewriteCmd.Flags().BoolVarP(&rewriteWrite, "write", "w", false, "Write changes to the source files instead of printing a diff")
+	rewriteCmd.MarkFlagRequired("rule")
+	addQueryFlags(rewriteCmd)
+}
@@ -4,11 +4,16 @@ Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
 package cmd
 
 import (
+	"cmp"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
 
+var dir string
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "plsdo",
@@ -21,8 +26,43 @@ var rootCmd = &cobra.Command{
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	if args, ok := dirShorthand(os.Args[1:]); ok {
+		rootCmd.SetArgs(args)
+	}
 	err := rootCmd.Execute()
 	if err != nil {
 		os.Exit(1)
 	}
 }
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&dir, "dir", "", "Run the command as if from this directory, resolving the workspace, packages and relative filenames from it; may also be given as -C dir before the command name, as with the go command")
+}
+
+// dirShorthand rewrites a leading -C dir or -C=dir as --dir.  As with the go
+// command, -C must come first: refs uses -C for the number of context lines.
+func dirShorthand(args []string) ([]string, bool) {
+	if len(args) > 1 && args[0] == "-C" {
+		return append([]string{"--dir", args[1]}, args[2:]...), true
+	}
+	if len(args) > 0 {
+		if d, ok := strings.CutPrefix(args[0], "-C="); ok {
+			return append([]string{"--dir=" + d}, args[1:]...), true
+		}
+	}
+	return nil, false
+}
+
+// workDir returns the directory the command runs in, as set by --dir.
+func workDir() string {
+	return cmp.Or(dir, ".")
+}
+
+// inDir returns a filename given on the command line resolved relative to the
+// directory set by --dir.
+func inDir(filename string) string {
+	if dir == "" || filename == "" || filepath.IsAbs(filename) {
+		return filename
+	}
+	return filepath.Join(dir, filename)
+}
@@ -0,0 +1,162 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/gwatts/plsdo/pkg/plsdo"
+	"github.com/gwatts/plsdo/pkg/server"
+	"github.com/spf13/cobra"
+)
+
+const remoteAuto = "auto"
+
+var (
+	listenAddr string
+	remote     string
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Runs a server answering queries with a warm gopls session",
+	Long: `Runs until interrupted, answering refs, stats, defs, impls and symbols
+queries from commands run with --remote, or from other tools using its HTTP/JSON
+API.  Keeping gopls running avoids loading the workspace for every query.
+
+By default the server listens on a Unix socket specific to the go.work workspace
+or module containing the current directory, which is used by --remote=auto from
+any directory within it.  --listen accepts a TCP address such as
+localhost:7070, or unix:<path>; over TCP, only requests addressed to a loopback
+host such as localhost are answered.  The build flags apply to every query.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		m := newMatcher(cmd)
+		defer m.Close()
+		addr := listenAddr
+		if addr == "" {
+			var err error
+			addr, err = server.DefaultAddress(m.Workspace().Dir())
+			cobra.CheckErr(err)
+		}
+		srv, err := server.New(m)
+		cobra.CheckErr(err)
+		if debug {
+			srv.Logger = os.Stderr
+		}
+		l, err := server.Listen(addr)
+		cobra.CheckErr(err)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			l.Close()
+		}()
+		fmt.Fprintf(os.Stderr, "Listening on %s\n", addr)
+		cobra.CheckErr(srv.Serve(l))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(&listenAddr, "listen", "", "Address to listen on: host:port or unix:<path> (default a socket for the workspace or module)")
+	addBuildFlags(serveCmd)
+}
+
+// addRemoteFlag adds the --remote flag, which sends queries to a server.
+func addRemoteFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&remote, "remote", os.Getenv("PLSDO_REMOTE"), `Send the query to a server started by "plsdo serve" at this address, or "auto" to use the server for the workspace or module if running (default $PLSDO_REMOTE)`)
+}
+
+// remoteClient returns a client for the server selected by --remote, or nil if
+// queries should run locally.
+func remoteClient() *server.Client {
+	if remote == "" {
+		return nil
+	}
+	if remote != remoteAuto {
+		return server.NewClient(remote)
+	}
+	ws, err := plsdo.FindWorkspace(workDir(), build.Env())
+	cobra.CheckErr(err)
+	addr, err := server.DefaultAddress(ws.Dir())
+	cobra.CheckErr(err)
+	client := server.NewClient(addr)
+	if _, err := client.Status(); err != nil {
+		if debug {
+			fmt.Fprintf(os.Stderr, "running locally: %v\n", err)
+		}
+		return nil
+	}
+	return client
+}
+
+// newQuery returns the server query selected by the arguments and query flags.
+func newQuery(cmd *cobra.Command, args []string) server.Query {
+	return server.Query{
+		Package:    absPackages(args[0]),
+		Patterns:   args[1:],
+		Visibility: queryVisibility(cmd),
+		Build:      build,
+	}
+}
+
+// newRefsRequest returns the server request for the references selected by the
+// arguments and query flags, leaving the output options unset.
+func newRefsRequest(cmd *cobra.Command, args []string) server.RefsRequest {
+	changes, err := queryChanges()
+	cobra.CheckErr(err)
+	q := server.Query{Visibility: queryVisibility(cmd), Build: build}
+	var queries []plsdo.Query
+	if queriesFile != "" {
+		queries = loadQueries(args)
+		for i := range queries {
+			queries[i].Package = absPackages(queries[i].Package)
+		}
+	} else {
+		q = newQuery(cmd, args)
+	}
+	req := server.RefsRequest{
+		Query:      q,
+		Queries:    queries,
+		Instances:  instances,
+		Filter:     filter,
+		Where:      where,
+		Statements: statements,
+		Blame:      blame,
+		Changes:    changes,
+	}
+	if cmd.Flags().Lookup("context") != nil {
+		req.ContextBefore, req.ContextAfter = contextLines(cmd)
+	}
+	if baseline != "" {
+		req.Baseline, err = plsdo.LoadRecords(inDir(baseline))
+		cobra.CheckErr(err)
+	}
+	return req
+}
+
+// absPackages makes the relative package patterns within a comma-separated list,
+// such as ./..., absolute, as the server may be running in another directory of
+// the workspace.
+func absPackages(pkgs string) string {
+	patterns := strings.Split(pkgs, ",")
+	for i, pattern := range patterns {
+		if pattern == "." || pattern == ".." || strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../") {
+			if abs, err := filepath.Abs(filepath.Join(workDir(), pattern)); err == nil {
+				patterns[i] = abs
+			}
+		}
+	}
+	return strings.Join(patterns, ",")
+}
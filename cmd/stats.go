@@ -0,0 +1,72 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package cmd
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gwatts/plsdo/pkg/plsdo"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statsOpts   plsdo.StatsOptions
+	statsFormat string
+)
+
+// statsCmd represents the stats command
+var statsCmd = &cobra.Command{
+	Use:   "stats <package[,package...]> <pattern> [pattern...]",
+	Short: "Summarizes references to specific functions or methods",
+	Long: `Counts references to the matching functions and methods, grouped by the
+referenced symbol, the calling package, the file and the enclosing function.
+
+Equivalent to refs --summary.`,
+	Args: queryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if client := remoteClient(); client != nil {
+			req := newRefsRequest(cmd, args)
+			req.Summary, req.SummaryJson = &statsOpts, statsFormat == fmtJson
+			_, err := client.Refs(req, os.Stdout)
+			cobra.CheckErr(err)
+			return
+		}
+
+		m := newMatcher(cmd)
+		defer m.Close()
+
+		cobra.CheckErr(m.FindQueries(loadQueries(args)...))
+		cobra.CheckErr(writeStats(os.Stdout, m, statsFormat == fmtJson))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+
+	statsCmd.Flags().StringVarP(&statsFormat, "fmt", "f", "table", "Output format: table or json")
+	addStatsFlags(statsCmd)
+	addQueryFlags(statsCmd)
+	addQueriesFlag(statsCmd)
+	addRemoteFlag(statsCmd)
+}
+
+// addStatsFlags adds the flags controlling how references are summarized.
+func addStatsFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&statsOpts.By, "by", nil, "Comma-separated dimensions to group by: "+strings.Join(plsdo.StatsDimensions, ", ")+" (default all)")
+	cmd.Flags().IntVar(&statsOpts.Top, "top", 0, "Only show the N most referenced keys for each dimension")
+	cmd.Flags().StringVar(&statsOpts.Sort, "sort", plsdo.SortCount, "Sort order: count or name")
+}
+
+func writeStats(w io.Writer, m *plsdo.Matcher, asJson bool) error {
+	stats, err := m.Stats(statsOpts)
+	if err != nil {
+		return err
+	}
+	if asJson {
+		return stats.WriteJson(w)
+	}
+	return stats.WriteTable(w)
+}
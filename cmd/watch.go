@@ -0,0 +1,70 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gwatts/plsdo/pkg/gopls"
+	"github.com/gwatts/plsdo/pkg/plsdo"
+	"github.com/gwatts/plsdo/pkg/watch"
+	"golang.org/x/term"
+)
+
+// watchRefs repeatedly runs find and redisplays the output of render each time
+// files in the workspace change, reusing the Matcher's gopls session.  It returns
+// when interrupted.
+func watchRefs(m *plsdo.Matcher, find func() error, render func(w io.Writer) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	poller, err := watch.NewPoller(m.Workspace().Root, watchPeriod)
+	if err != nil {
+		return err
+	}
+	clearScreen := term.IsTerminal(int(os.Stdout.Fd()))
+	for {
+		// render to a buffer so the previous results remain visible while searching
+		var buf bytes.Buffer
+		m.Reset()
+		err := find()
+		if err == nil {
+			err = render(&buf)
+		}
+		if clearScreen {
+			os.Stdout.WriteString("\x1b[H\x1b[2J")
+		}
+		os.Stdout.Write(buf.Bytes())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		fmt.Fprintf(os.Stderr, "[%s] %d references; watching for changes, press Ctrl+C to stop\n",
+			time.Now().Format(time.TimeOnly), len(m.Records()))
+
+		events, err := poller.Wait(ctx)
+		if errors.Is(err, context.Canceled) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		changes := make([]gopls.FileEvent, 0, len(events))
+		for _, ev := range events {
+			if debug {
+				fmt.Fprintf(os.Stderr, "%s %s\n", ev.Op, ev.Filename)
+			}
+			changes = append(changes, gopls.FileEvent{Filename: ev.Filename, Type: gopls.FileChangeType(ev.Op)})
+		}
+		if err := m.FilesChanged(changes); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package ast
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+)
+
+// Argument kinds reported by CallArgs.
+const (
+	ArgLiteral   = "literal"
+	ArgIdent     = "ident"
+	ArgCall      = "call"
+	ArgComposite = "composite"
+	ArgSelector  = "selector"
+	ArgFunc      = "func"
+	ArgUnary     = "unary"
+	ArgBinary    = "binary"
+	ArgOther     = "expr"
+)
+
+// Arg describes a single argument passed to a matched call.
+type Arg struct {
+	Source   string // source code of the argument
+	Kind     string // one of the Arg* kinds
+	Type     string // type of the argument, qualified by package name
+	Constant bool   // true if the argument is a compile-time constant
+}
+
+// CallArgs returns the arguments of the call expression corresponding to the reference,
+// using go/types to determine the type of each argument.  ErrNoCall is returned if
+// the reference is not the function called, as for a declaration or a function value
+// passed to another call.
+func (a *ASTProcessor) CallArgs(filePath string, line, character int) ([]Arg, error) {
+	pkg, err := a.TypePackage(filePath)
+	if err != nil {
+		return nil, err
+	}
+	file := syntaxFile(pkg, filePath)
+	if file == nil {
+		return nil, fmt.Errorf("file %s not found in package %s", filePath, pkg.PkgPath)
+	}
+	src, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file %s: %v", filePath, err)
+	}
+
+	tf := pkg.Fset.File(file.Pos())
+	if line < 1 || line > tf.LineCount() {
+		return nil, fmt.Errorf("invalid position")
+	}
+	call := findCalleeCall(file, tf.LineStart(line)+token.Pos(character-1))
+	if call == nil {
+		return nil, ErrNoCall
+	}
+
+	qualifier := func(p *types.Package) string { return p.Name() }
+	args := make([]Arg, len(call.Args))
+	for i, expr := range call.Args {
+		args[i] = Arg{Kind: argKind(expr)}
+		if start, end := tf.Offset(expr.Pos()), tf.Offset(expr.End()); end <= len(src) {
+			args[i].Source = string(src[start:end])
+		}
+		if pkg.TypesInfo == nil {
+			continue
+		}
+		if tv, ok := pkg.TypesInfo.Types[expr]; ok {
+			if tv.Type != nil {
+				args[i].Type = types.TypeString(tv.Type, qualifier)
+			}
+			args[i].Constant = tv.Value != nil
+		}
+	}
+	return args, nil
+}
+
+// argKind classifies an argument expression.
+func argKind(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		return ArgLiteral
+	case *ast.Ident:
+		return ArgIdent
+	case *ast.CallExpr:
+		return ArgCall
+	case *ast.CompositeLit:
+		return ArgComposite
+	case *ast.SelectorExpr:
+		return ArgSelector
+	case *ast.FuncLit:
+		return ArgFunc
+	case *ast.UnaryExpr:
+		return ArgUnary
+	case *ast.BinaryExpr:
+		return ArgBinary
+	case *ast.ParenExpr:
+		return argKind(e.X)
+	}
+	return ArgOther
+}
@@ -0,0 +1,144 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package ast
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeModule writes a module named example.com/test containing files, returning
+// its directory.
+func writeModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	files["go.mod"] = "module example.com/test\n\ngo 1.23\n"
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// position returns the 1-based line and column of the n'th occurrence of substr
+// within src, counting from zero.
+func position(t *testing.T, src, substr string, n int) (int, int) {
+	t.Helper()
+	offset := 0
+	for i := 0; ; i++ {
+		idx := strings.Index(src[offset:], substr)
+		if idx < 0 {
+			t.Fatalf("occurrence %d of %q not found", n, substr)
+		}
+		offset += idx
+		if i == n {
+			break
+		}
+		offset += len(substr)
+	}
+	line := strings.Count(src[:offset], "\n") + 1
+	return line, offset - strings.LastIndex(src[:offset], "\n")
+}
+
+func TestCallArgs(t *testing.T) {
+	const src = `package test
+
+type Handler struct{}
+
+func (h Handler) Serve(name string, n int) {}
+
+func run(f func(string, int)) {}
+
+func use(h Handler) {
+	h.Serve("x", 1)
+	run(h.Serve)
+}
+`
+	dir := writeModule(t, map[string]string{"test.go": src})
+	filename := filepath.Join(dir, "test.go")
+
+	tests := []struct {
+		name     string
+		n        int // occurrence of "Serve"
+		wantArgs []string
+		wantErr  error
+	}{
+		{name: "declaration", n: 0, wantErr: ErrNoCall},
+		{name: "call", n: 1, wantArgs: []string{`"x"`, "1"}},
+		{name: "method value argument", n: 2, wantErr: ErrNoCall},
+	}
+	a := NewASTProcessor()
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			line, col := position(t, src, "Serve", tc.n)
+			args, err := a.CallArgs(filename, line, col)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("CallArgs returned %v, %v; want error %v", args, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, arg := range args {
+				got = append(got, arg.Source)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.wantArgs, ",") {
+				t.Errorf("CallArgs sources = %q, want %q", got, tc.wantArgs)
+			}
+		})
+	}
+}
+
+// TestCallArgsTestFile checks that references in test files can be type checked
+// without --tests, which only selects the definitions matched.
+func TestCallArgsTestFile(t *testing.T) {
+	files := map[string]string{
+		"lib.go":      "package test\n\nfunc Do(n int) {}\n",
+		"lib_test.go": "package test\n\nimport \"testing\"\n\nfunc TestDo(t *testing.T) {\n\tDo(1)\n}\n",
+		"ext_test.go": "package test_test\n\nimport (\n\t\"testing\"\n\n\t\"example.com/test\"\n)\n\nfunc TestExt(t *testing.T) {\n\ttest.Do(2)\n}\n",
+	}
+	dir := writeModule(t, files)
+
+	tests := []struct {
+		file    string
+		wantArg string
+		wantPkg string
+	}{
+		{file: "lib_test.go", wantArg: "1", wantPkg: "example.com/test"},
+		{file: "ext_test.go", wantArg: "2", wantPkg: "example.com/test_test"},
+	}
+	a := NewASTProcessor()
+	a.Dir = dir
+	for _, tc := range tests {
+		t.Run(tc.file, func(t *testing.T) {
+			filename := filepath.Join(dir, tc.file)
+			line, col := position(t, files[tc.file], "Do("+tc.wantArg, 0)
+			args, err := a.CallArgs(filename, line, col)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(args) != 1 || args[0].Source != tc.wantArg || args[0].Type != "int" {
+				t.Errorf("CallArgs returned %+v, want one int argument %s", args, tc.wantArg)
+			}
+			pkgs, err := a.FilePackages(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pkg := pkgs[filename]; pkg == nil || pkg.PkgPath != tc.wantPkg {
+				t.Errorf("FilePackages returned %v, want package %s", pkg, tc.wantPkg)
+			}
+		})
+	}
+}
@@ -7,17 +7,17 @@ package ast
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"go/ast"
-	"go/build"
 	"go/parser"
 	"go/printer"
 	"go/token"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/ryanuber/go-glob"
+	"golang.org/x/tools/go/packages"
 )
 
 // Match holds a matching function reference located by FindFuncDefinitions.
@@ -26,6 +26,8 @@ type Match struct {
 	RecvType   string
 	RecvName   string
 	FuncName   string
+	TypeParams string // type parameter list of a generic function, eg. "[K, V]"
+	Pattern    string // the first pattern that matched the function
 	Filename   string
 	OffsetLine int
 	OffsetCol  int
@@ -40,13 +42,74 @@ func (m Match) MethodName() string {
 			return fmt.Sprintf("(%s) %s(...)", m.RecvType, m.FuncName)
 		}
 	}
-	return fmt.Sprintf("%s(...)", m.FuncName)
+	return fmt.Sprintf("%s%s(...)", m.FuncName, m.TypeParams)
+}
+
+// Visibility controls whether FindFuncDefinitions considers unexported functions.
+type Visibility int
+
+const (
+	// VisibilityAuto includes unexported functions only for packages within the main module.
+	VisibilityAuto Visibility = iota
+	// VisibilityExported includes only exported functions.
+	VisibilityExported
+	// VisibilityAll includes both exported and unexported functions.
+	VisibilityAll
+)
+
+// BuildConfig controls which files are considered when loading packages.
+type BuildConfig struct {
+	Tests  bool     // match definitions in _test.go files
+	GOOS   string   // target operating system; defaults to the host
+	GOARCH string   // target architecture; defaults to the host
+	Tags   []string // additional build tags
+}
+
+// Env returns the environment used to run the go command for this configuration.
+func (bc BuildConfig) Env() []string {
+	env := os.Environ()
+	if bc.GOOS != "" {
+		env = append(env, "GOOS="+bc.GOOS)
+	}
+	if bc.GOARCH != "" {
+		env = append(env, "GOARCH="+bc.GOARCH)
+	}
+	return env
+}
+
+// BuildFlags returns the go command build flags for this configuration.
+func (bc BuildConfig) BuildFlags() []string {
+	if len(bc.Tags) == 0 {
+		return nil
+	}
+	return []string{"-tags=" + strings.Join(bc.Tags, ",")}
+}
+
+// Symbol returns the name of the function, or the base type and name of a method,
+// eg. Logger.Info
+func (m Match) Symbol() string {
+	if m.RecvType != "" {
+		return stripTypeParams(strings.TrimPrefix(m.RecvType, "*")) + "." + m.FuncName
+	}
+	return m.FuncName
 }
 
 // ASTProcessor handles parsing source files and extracting method calls.
 type ASTProcessor struct {
-	fset    *token.FileSet
-	fileMap map[string]*ast.File // Cache parsed files
+	fset       *token.FileSet
+	fileMap    map[string]*ast.File         // Cache parsed files
+	srcMap     map[string][]byte            // Cache source of parsed files
+	typeMap    map[string]*packages.Package // Cache type-checked packages by filename
+	defFiles   []string                     // files searched by FindFuncDefinitions
+	Visibility Visibility
+	Build      BuildConfig
+	// Dir is the directory in which packages are loaded, determining the main
+	// module and how relative patterns such as ./... are resolved.  If empty,
+	// the current directory is used.
+	Dir string
+	// Interfaces includes the methods declared by interface types in the
+	// definitions found, matched as `TypeName.MethodName`.
+	Interfaces bool
 }
 
 // NewASTProcessor creates a new ASTProcessor.
@@ -54,6 +117,8 @@ func NewASTProcessor() *ASTProcessor {
 	return &ASTProcessor{
 		fset:    token.NewFileSet(),
 		fileMap: make(map[string]*ast.File),
+		srcMap:  make(map[string][]byte),
+		typeMap: make(map[string]*packages.Package),
 	}
 }
 
@@ -76,67 +141,249 @@ func (a *ASTProcessor) ParseFile(filePath string) error {
 	}
 
 	a.fileMap[filePath] = file
+	a.srcMap[filePath] = src
 	return nil
 }
 
+// Forget discards the cached contents of files that have changed, so they are
+// parsed again when next required.  Type information is discarded for every
+// package, as it may depend on the changed files.
+func (a *ASTProcessor) Forget(filePaths ...string) {
+	for _, filePath := range filePaths {
+		delete(a.fileMap, filePath)
+		delete(a.srcMap, filePath)
+	}
+	if len(filePaths) > 0 {
+		clear(a.typeMap)
+	}
+}
+
+// ErrNoCall is returned when no call expression corresponds to a reference.
+var ErrNoCall = errors.New("no corresponding call expression found")
+
+// Snippet is a region of source code extracted from a file.
+type Snippet struct {
+	Source    string
+	StartLine int // 1-based indexing
+	EndLine   int // 1-based indexing
+	RefOffset int // byte offset within Source of the requested position
+}
+
 // ExtractFullCall extracts the call expression corresponding to the reference.
 func (a *ASTProcessor) ExtractFullCall(filePath string, line, character int) (string, error) {
+	snippet, err := a.ExtractCall(filePath, line, character)
+	return snippet.Source, err
+}
+
+// ExtractCall extracts the call expression corresponding to the reference, being
+// the call whose function name is at the reference position.  For chained calls
+// such as log.With(...).Info(...) this is the call to the referenced method, rather
+// than the innermost call containing the position.
+func (a *ASTProcessor) ExtractCall(filePath string, line, character int) (Snippet, error) {
 	// Ensure the file is parsed
 	if err := a.ParseFile(filePath); err != nil {
-		return "", err
+		return Snippet{}, err
 	}
 
 	file := a.fileMap[filePath]
-	src, err := os.ReadFile(filePath)
-	if err != nil {
-		return "", fmt.Errorf("error reading file %s: %v", filePath, err)
+
+	// Get the position in token.Pos
+	position := a.getPosition(filePath, line, character)
+	if position == token.NoPos {
+		return Snippet{}, fmt.Errorf("invalid position")
+	}
+
+	targetCallExpr := findCallExpr(file, position)
+	if targetCallExpr == nil {
+		return Snippet{}, ErrNoCall
 	}
+	return a.snippet(filePath, targetCallExpr.Pos(), targetCallExpr.End(), position)
+}
+
+// ExtractStatement extracts the innermost statement containing the reference.
+// For compound statements such as if, for and switch only the header is returned.
+// References outside of any statement, such as in a declaration, return the
+// source line containing the reference.
+func (a *ASTProcessor) ExtractStatement(filePath string, line, character int) (Snippet, error) {
+	// Ensure the file is parsed
+	if err := a.ParseFile(filePath); err != nil {
+		return Snippet{}, err
+	}
+
+	file := a.fileMap[filePath]
 
 	// Get the position in token.Pos
 	position := a.getPosition(filePath, line, character)
 	if position == token.NoPos {
-		return "", fmt.Errorf("invalid position")
+		return Snippet{}, fmt.Errorf("invalid position")
 	}
 
-	var targetCallExpr *ast.CallExpr
+	var stmt ast.Stmt
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil || position < n.Pos() || position > n.End() {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.BlockStmt, *ast.CaseClause, *ast.CommClause:
+		case ast.Stmt:
+			stmt = n
+		}
+		return true
+	})
+
+	if stmt == nil {
+		lines, err := a.Lines(filePath, line, line)
+		if err != nil {
+			return Snippet{}, err
+		}
+		source := strings.TrimSpace(lines[0])
+		offset := a.fset.Position(position).Column - 1 - strings.Index(lines[0], source)
+		return Snippet{Source: source, StartLine: line, EndLine: line, RefOffset: offset}, nil
+	}
+
+	end := stmt.End()
+	var body *ast.BlockStmt
+	switch s := stmt.(type) {
+	case *ast.IfStmt:
+		body = s.Body
+	case *ast.ForStmt:
+		body = s.Body
+	case *ast.RangeStmt:
+		body = s.Body
+	case *ast.SwitchStmt:
+		body = s.Body
+	case *ast.TypeSwitchStmt:
+		body = s.Body
+	case *ast.SelectStmt:
+		body = s.Body
+	}
+	if body != nil && position < body.Lbrace {
+		end = body.Lbrace + 1
+	}
+	return a.snippet(filePath, stmt.Pos(), end, position)
+}
 
-	// Walk the AST to find the innermost CallExpr containing the position
+// Lines returns the source lines from..to inclusive, using 1-based indexing.
+// The range is clipped to the lines within the file.
+func (a *ASTProcessor) Lines(filePath string, from, to int) ([]string, error) {
+	if err := a.ParseFile(filePath); err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(a.srcMap[filePath]), "\n")
+	from = max(from, 1)
+	to = min(to, len(lines))
+	if from > to {
+		return nil, nil
+	}
+	return lines[from-1 : to], nil
+}
+
+// snippet returns the source code between two positions, recording the offset of ref.
+func (a *ASTProcessor) snippet(filePath string, start, end, ref token.Pos) (Snippet, error) {
+	src := a.srcMap[filePath]
+	startPos, endPos := a.fset.Position(start), a.fset.Position(end)
+	if startPos.Offset < 0 || endPos.Offset > len(src) || startPos.Offset >= endPos.Offset {
+		return Snippet{}, fmt.Errorf("invalid source positions")
+	}
+	return Snippet{
+		Source:    string(src[startPos.Offset:endPos.Offset]),
+		StartLine: startPos.Line,
+		EndLine:   endPos.Line,
+		RefOffset: int(ref - start),
+	}, nil
+}
+
+// findCallExpr walks the AST to find the call expression corresponding to the position.
+// It prefers the innermost call whose function name is at the position, falling back
+// to the innermost call containing the position.
+func findCallExpr(file *ast.File, position token.Pos) *ast.CallExpr {
+	direct, containing := findCalls(file, position)
+	if direct != nil {
+		return direct
+	}
+	return containing
+}
+
+// findCalleeCall returns the innermost call whose function name is at the position,
+// or nil if the reference at the position is not called, as for a function value
+// passed as an argument.
+func findCalleeCall(file *ast.File, position token.Pos) *ast.CallExpr {
+	direct, _ := findCalls(file, position)
+	return direct
+}
+
+// findCalls returns the innermost call whose function name is at the position, and
+// the innermost call containing the position.
+func findCalls(file *ast.File, position token.Pos) (direct, containing *ast.CallExpr) {
 	ast.Inspect(file, func(n ast.Node) bool {
-		if n == nil {
+		if n == nil || position < n.Pos() || position > n.End() {
 			return false
 		}
 
 		if ce, ok := n.(*ast.CallExpr); ok {
-			if ce.Pos() <= position && position <= ce.End() {
-				targetCallExpr = ce
-				// Continue traversing to find deeper CallExprs
-				return true
+			containing = ce
+			if id := calleeIdent(ce.Fun); id != nil && id.Pos() <= position && position <= id.End() {
+				direct = ce
 			}
 		}
+		// Continue traversing to find deeper CallExprs
 		return true
 	})
+	return direct, containing
+}
 
-	if targetCallExpr == nil {
-		return "", fmt.Errorf("no corresponding call expression found")
+// calleeIdent returns the identifier naming the function called by a call expression,
+// eg. Info in log.With(...).Info or Map in Map[string, int].
+func calleeIdent(fun ast.Expr) *ast.Ident {
+	switch f := fun.(type) {
+	case *ast.Ident:
+		return f
+	case *ast.SelectorExpr:
+		return f.Sel
+	case *ast.ParenExpr:
+		return calleeIdent(f.X)
+	case *ast.IndexExpr:
+		return calleeIdent(f.X)
+	case *ast.IndexListExpr:
+		return calleeIdent(f.X)
 	}
+	return nil
+}
 
-	// Extract the source code corresponding to the targetCallExpr
-	startOffset := a.fset.Position(targetCallExpr.Pos()).Offset
-	endOffset := a.fset.Position(targetCallExpr.End()).Offset
-	if startOffset < 0 || endOffset > len(src) || startOffset >= endOffset {
-		return "", fmt.Errorf("invalid call expression positions")
-	}
+// Enclosing describes the lexical context of a reference.
+type Enclosing struct {
+	Package  string   // name of the package containing the reference
+	FuncName string   // name of the enclosing named function, or "global scope"
+	RecvType string   // receiver type if the named function is a method
+	RecvName string   // receiver name if the named function is a method
+	Chain    []string // full lexical chain, eg. (*Server).Start › func literal #2 › goroutine
+	InGo     bool     // reference is run by the goroutine of a go statement
+	InDefer  bool     // reference is run by the call of a defer statement
+}
 
-	snippet := string(src[startOffset:endOffset])
-	return snippet, nil
+// ChainSeparator separates the elements of an enclosing chain.
+const ChainSeparator = " › "
+
+// String returns the full lexical chain of the enclosing context.
+func (e Enclosing) String() string {
+	return strings.Join(e.Chain, ChainSeparator)
 }
 
-// GetEnclosingFunctionName finds the name and receiver type of the function/method
-// containing the given position.
+// GetEnclosingFunctionName finds the name and receiver type of the named function/method
+// containing the given position.  References within closures report the named
+// function containing the closure.
 func (a *ASTProcessor) GetEnclosingFunctionName(filePath string, line, character int) (functionName, receiverType, receiverName string, err error) {
+	enc, err := a.GetEnclosing(filePath, line, character)
+	return enc.FuncName, enc.RecvType, enc.RecvName, err
+}
+
+// GetEnclosing finds the full lexical context of the given position, including any
+// function literals and go or defer statements between the enclosing named function
+// and the reference.
+func (a *ASTProcessor) GetEnclosing(filePath string, line, character int) (enc Enclosing, err error) {
 	// Ensure the file is parsed
 	if err := a.ParseFile(filePath); err != nil {
-		return "", "", "", err
+		return enc, err
 	}
 
 	file := a.fileMap[filePath]
@@ -144,98 +391,310 @@ func (a *ASTProcessor) GetEnclosingFunctionName(filePath string, line, character
 	// Get the position in token.Pos
 	position := a.getPosition(filePath, line, character)
 	if position == token.NoPos {
-		return "", "", "", fmt.Errorf("invalid position")
+		return enc, fmt.Errorf("invalid position")
 	}
 
-	// Find the enclosing function or method
-	var found bool
+	enc.Package = file.Name.Name
+	enc.FuncName = "global scope"
 
+	// scope is the innermost function, or the file, within which function
+	// literals are numbered
+	var scope ast.Node = file
 	ast.Inspect(file, func(n ast.Node) bool {
-		if n == nil || found {
+		if n == nil || position < n.Pos() || position > n.End() {
 			return false
 		}
-		if n.Pos() <= position && position <= n.End() {
-			switch fn := n.(type) {
-			case *ast.FuncDecl:
-				functionName = fn.Name.Name
-				if fn.Recv != nil && len(fn.Recv.List) > 0 {
-					// Get the receiver type
-					receiverType = exprToString(fn.Recv.List[0].Type)
-					if len(fn.Recv.List[0].Names) > 0 {
-						receiverName = fn.Recv.List[0].Names[0].Name
-					} else {
-						receiverName = "" // Anonymous receiver
-					}
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			enc.FuncName = n.Name.Name + typeParamNames(n.Type.TypeParams)
+			name := enc.FuncName
+			if n.Recv != nil && len(n.Recv.List) > 0 {
+				// Get the receiver type
+				enc.RecvType = exprToString(n.Recv.List[0].Type)
+				if len(n.Recv.List[0].Names) > 0 {
+					enc.RecvName = n.Recv.List[0].Names[0].Name
 				}
-				found = true
-				return false
-			case *ast.FuncLit:
-				functionName = "anonymous function"
-				receiverType = ""
-				found = true
-				return false
+				name = fmt.Sprintf("(%s).%s", enc.RecvType, enc.FuncName)
+			}
+			enc.Chain = append(enc.Chain, name)
+			scope = n
+		case *ast.FuncLit:
+			if len(enc.Chain) == 0 {
+				enc.Chain = append(enc.Chain, enc.FuncName)
+			}
+			enc.Chain = append(enc.Chain, fmt.Sprintf("func literal #%d", funcLitIndex(scope, n)))
+			scope = n
+		case *ast.GoStmt:
+			if runByCall(n.Call, position) {
+				enc.Chain = append(enc.Chain, "goroutine")
+				enc.InGo = true
+			}
+		case *ast.DeferStmt:
+			if runByCall(n.Call, position) {
+				enc.Chain = append(enc.Chain, "deferred")
+				enc.InDefer = true
 			}
 		}
 		return true
 	})
 
-	if !found {
-		functionName = "global scope"
-		receiverType = ""
+	if len(enc.Chain) == 0 {
+		enc.Chain = []string{enc.FuncName}
 	}
+	return enc, nil
+}
 
-	return functionName, receiverType, receiverName, nil
+// funcLitIndex returns the 1-based index of a function literal among those directly
+// within scope, in source order.  Literals nested within other literals are not
+// counted, so adding a closure to one function literal doesn't renumber another.
+func funcLitIndex(scope ast.Node, lit *ast.FuncLit) int {
+	index := 0
+	ast.Inspect(scope, func(n ast.Node) bool {
+		if n == scope {
+			return true
+		}
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			if n.Pos() <= lit.Pos() {
+				index++
+			}
+			return false
+		case *ast.FuncDecl:
+			return false
+		}
+		return true
+	})
+	return index
+}
+
+// runByCall reports whether the position is within the code run by the call of a
+// go or defer statement, rather than evaluated when the statement executes: the
+// body of a called function literal, or the name of the called function.
+func runByCall(call *ast.CallExpr, position token.Pos) bool {
+	if lit, ok := ast.Unparen(call.Fun).(*ast.FuncLit); ok {
+		return lit.Body.Pos() <= position && position <= lit.Body.End()
+	}
+	id := calleeIdent(call.Fun)
+	return id != nil && id.Pos() <= position && position <= id.End()
 }
 
-// FindFuncDefinitions locates the position of all supplied exported functions or methods
-// within a package.  funcPattern is one or more globs.
+// FindFuncDefinitions locates the position of all supplied functions or methods
+// within one or more packages.  Unexported functions are included according to
+// the processor's Visibility.  pkgPath is a comma-separated list of import paths or
+// go list patterns such as ./... or example.com/lib/...
+// funcPattern is one or more globs.
 // methods can be specified as `TypeName.MethodName`
 func (a *ASTProcessor) FindFuncDefinitions(pkgPath string, funcPattern ...string) (matches []Match, err error) {
-	pkg, err := build.Import(pkgPath, "", 0)
+	pkgs, err := a.LoadPackages(SplitPackages(pkgPath)...)
 	if err != nil {
 		return nil, err
 	}
-	for _, file := range pkg.GoFiles {
-		fullPath := filepath.Join(pkg.Dir, file)
-		if err := a.ParseFile(fullPath); err != nil {
-			return nil, err
+	a.defFiles = nil
+	seen := make(map[string]bool)
+	for _, pkg := range pkgs {
+		unexported := a.includeUnexported(pkg)
+		for _, fullPath := range pkg.GoFiles {
+			// test variants of a package repeat its non-test files
+			if seen[fullPath] {
+				continue
+			}
+			seen[fullPath] = true
+			a.defFiles = append(a.defFiles, fullPath)
+			if err := a.ParseFile(fullPath); err != nil {
+				return nil, err
+			}
+			node := a.fileMap[fullPath]
+			for _, decl := range node.Decls {
+				switch decl := decl.(type) {
+				case *ast.FuncDecl:
+					// Check if it's an exported function
+					funcName := decl.Name.Name
+					if !unexported && !ast.IsExported(funcName) {
+						continue
+					}
+					pattern, ok := a.isFuncMatch(decl, funcPattern...)
+					if !ok {
+						continue
+					}
+
+					pos := a.fset.Position(decl.Name.NamePos)
+					recvType, recvName := extractRecvType(decl)
+					match := Match{
+						Pkg:        pkg.PkgPath,
+						RecvType:   recvType,
+						RecvName:   recvName,
+						FuncName:   decl.Name.Name,
+						TypeParams: typeParamNames(decl.Type.TypeParams),
+						Pattern:    pattern,
+						Filename:   pos.Filename,
+						OffsetLine: pos.Line,
+						OffsetCol:  pos.Column,
+					}
+					matches = append(matches, match)
+				case *ast.GenDecl:
+					if a.Interfaces {
+						matches = append(matches, a.interfaceMethods(pkg.PkgPath, decl, unexported, funcPattern)...)
+					}
+				}
+			}
 		}
-		node := a.fileMap[fullPath]
-		for _, decl := range node.Decls {
-			switch decl := decl.(type) {
-			case *ast.FuncDecl:
-				// Check if it's an exported function
-				funcName := decl.Name.Name
-				if !ast.IsExported(funcName) {
+	}
+	return matches, err
+}
+
+// interfaceMethods returns the methods of interface types declared by decl that
+// match any of the supplied patterns.
+func (a *ASTProcessor) interfaceMethods(pkgPath string, decl *ast.GenDecl, unexported bool, funcPattern []string) []Match {
+	var matches []Match
+	for _, spec := range decl.Specs {
+		ts, ok := spec.(*ast.TypeSpec)
+		if !ok {
+			continue
+		}
+		iface, ok := ts.Type.(*ast.InterfaceType)
+		if !ok || (!unexported && !ast.IsExported(ts.Name.Name)) {
+			continue
+		}
+		for _, field := range iface.Methods.List {
+			// embedded interfaces and type constraints have no names
+			if _, ok := field.Type.(*ast.FuncType); !ok {
+				continue
+			}
+			for _, name := range field.Names {
+				if !unexported && !ast.IsExported(name.Name) {
 					continue
 				}
-				if !a.isFuncMatch(decl, funcPattern...) {
+				pattern, ok := matchFuncPattern(ts.Name.Name, name.Name, funcPattern...)
+				if !ok {
 					continue
 				}
-
-				pos := a.fset.Position(decl.Name.NamePos)
-				recvType, recvName := extractRecvType(decl)
-				match := Match{
-					Pkg:      pkgPath,
-					RecvType: recvType,
-					RecvName: recvName,
-					//RecvType:   extractRecvType(decl),
-					FuncName:   decl.Name.Name,
+				pos := a.fset.Position(name.NamePos)
+				matches = append(matches, Match{
+					Pkg:        pkgPath,
+					RecvType:   ts.Name.Name,
+					FuncName:   name.Name,
+					Pattern:    pattern,
 					Filename:   pos.Filename,
 					OffsetLine: pos.Line,
 					OffsetCol:  pos.Column,
-				}
-				matches = append(matches, match)
+				})
 			}
 		}
 	}
-	return matches, err
+	return matches
+}
+
+// DefinitionFiles returns the files searched by FindFuncDefinitions.
+func (a *ASTProcessor) DefinitionFiles() []string {
+	return a.defFiles
+}
+
+// LoadPackages resolves one or more import paths or patterns using go list semantics,
+// so wildcards such as ./... expand to every matching package.
+func (a *ASTProcessor) LoadPackages(patterns ...string) ([]*packages.Package, error) {
+	cfg := a.packagesConfig(packages.NeedName | packages.NeedFiles | packages.NeedModule)
+	loaded, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []*packages.Package
+	for _, pkg := range loaded {
+		// skip the synthesized main package of a test binary
+		if strings.HasSuffix(pkg.PkgPath, ".test") {
+			continue
+		}
+		for _, perr := range pkg.Errors {
+			return nil, fmt.Errorf("error loading package %s: %v", pkg.PkgPath, perr)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("no packages matched %s", strings.Join(patterns, ", "))
+	}
+	return pkgs, nil
+}
+
+// FilePackages returns the package containing each of the supplied files, keyed
+// by filename.  As with TypePackage, the files may be test files.
+func (a *ASTProcessor) FilePackages(files ...string) (map[string]*packages.Package, error) {
+	var patterns []string
+	want := make(map[string]bool)
+	for _, file := range files {
+		if !want[file] {
+			want[file] = true
+			patterns = append(patterns, "file="+file)
+		}
+	}
+	cfg := a.packagesConfig(packages.NeedName | packages.NeedFiles | packages.NeedModule)
+	cfg.Tests = true
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*packages.Package)
+	for _, pkg := range pkgs {
+		for _, file := range pkg.GoFiles {
+			if _, found := result[file]; want[file] && !found {
+				result[file] = pkg
+			}
+		}
+	}
+	return result, nil
+}
+
+// packagesConfig returns a packages.Config honouring the processor's build configuration.
+func (a *ASTProcessor) packagesConfig(mode packages.LoadMode) *packages.Config {
+	return &packages.Config{
+		Mode:       mode,
+		Dir:        a.Dir,
+		Tests:      a.Build.Tests,
+		Env:        a.Build.Env(),
+		BuildFlags: a.Build.BuildFlags(),
+	}
+}
+
+// includeUnexported reports whether unexported functions in pkg should be matched.
+func (a *ASTProcessor) includeUnexported(pkg *packages.Package) bool {
+	switch a.Visibility {
+	case VisibilityAll:
+		return true
+	case VisibilityExported:
+		return false
+	}
+	return pkg.Module != nil && pkg.Module.Main
 }
 
-func (a *ASTProcessor) isFuncMatch(node *ast.FuncDecl, funcPatterns ...string) bool {
-	recvType, _ := extractRecvType(node)
-	recvType = strings.TrimPrefix(recvType, "*")
-	funcName := node.Name.Name
+// SplitPackages splits a comma-separated list of package paths or patterns.
+func SplitPackages(pkgPath string) (patterns []string) {
+	for _, p := range strings.Split(pkgPath, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+func (a *ASTProcessor) isFuncMatch(node *ast.FuncDecl, funcPatterns ...string) (pattern string, ok bool) {
+	// generic receivers such as List[T] are matched by their base type name
+	recvType := ""
+	if node.Recv != nil && len(node.Recv.List) == 1 {
+		recvType = baseTypeName(node.Recv.List[0].Type)
+	}
+	return matchFuncPattern(recvType, node.Name.Name, funcPatterns...)
+}
+
+// MatchFuncName reports whether a function or method name matches any of the supplied
+// globs.  Methods are matched using `TypeName.MethodName` patterns; pointers and type
+// parameters are ignored, so *List[T] matches `List.*`.
+func MatchFuncName(recvType, funcName string, funcPatterns ...string) bool {
+	_, ok := matchFuncPattern(recvType, funcName, funcPatterns...)
+	return ok
+}
+
+// matchFuncPattern returns the first of the supplied globs matching a function or method name.
+func matchFuncPattern(recvType, funcName string, funcPatterns ...string) (string, bool) {
+	recvType = stripTypeParams(strings.TrimPrefix(recvType, "*"))
+	funcName = stripTypeParams(funcName)
 	for _, pattern := range funcPatterns {
 		matchFunc := pattern
 		if structName, methodName, found := strings.Cut(pattern, "."); found {
@@ -246,10 +705,10 @@ func (a *ASTProcessor) isFuncMatch(node *ast.FuncDecl, funcPatterns ...string) b
 		}
 
 		if glob.Glob(matchFunc, funcName) {
-			return true // match
+			return pattern, true // match
 		}
 	}
-	return false
+	return "", false
 }
 
 // getPosition converts line and character to token.Pos
@@ -277,6 +736,44 @@ func extractRecvType(decl *ast.FuncDecl) (recvType, recvName string) {
 	return recvType, recvName
 }
 
+// baseTypeName returns the name of a receiver type with any pointer
+// and type parameters removed, eg. *List[T] becomes List.
+func baseTypeName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return baseTypeName(e.X)
+	case *ast.ParenExpr:
+		return baseTypeName(e.X)
+	case *ast.IndexExpr:
+		return baseTypeName(e.X)
+	case *ast.IndexListExpr:
+		return baseTypeName(e.X)
+	}
+	return exprToString(expr)
+}
+
+// stripTypeParams removes a trailing type parameter list from a name, eg. List[T] becomes List.
+func stripTypeParams(name string) string {
+	if i := strings.IndexByte(name, '['); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// typeParamNames renders a type parameter list as eg. "[K, V]".
+func typeParamNames(fields *ast.FieldList) string {
+	if fields == nil || len(fields.List) == 0 {
+		return ""
+	}
+	var names []string
+	for _, field := range fields.List {
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
 // exprToString converts an expression to its string representation.
 func exprToString(expr ast.Expr) string {
 	switch e := expr.(type) {
@@ -309,18 +806,32 @@ func exprToString(expr ast.Expr) string {
 	}
 }
 
-// Format re-formats the supplied source code.
+// Format re-formats the supplied source code, which may be an expression or
+// one or more statements.
 func Format(source string) string {
 	fset := token.NewFileSet()
+	prt := &printer.Config{
+		Mode:     printer.UseSpaces,
+		Tabwidth: 4,
+	}
+	var buf bytes.Buffer
 	expr, err := parser.ParseExprFrom(fset, "", []byte(source), 0)
+	if err == nil {
+		prt.Fprint(&buf, fset, expr)
+		return buf.String()
+	}
+
+	// wrap statements in a function so they can be parsed
+	file, err := parser.ParseFile(fset, "", "package p; func _() {\n"+source+"\n}", 0)
 	if err != nil {
 		return source
 	}
-	var buf bytes.Buffer
-	prt := &printer.Config{
-		Mode:     printer.UseSpaces,
-		Tabwidth: 4,
+	body := file.Decls[0].(*ast.FuncDecl).Body
+	for i, stmt := range body.List {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		prt.Fprint(&buf, fset, stmt)
 	}
-	prt.Fprint(&buf, fset, expr)
 	return buf.String()
 }
@@ -0,0 +1,103 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package ast
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBuildConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		bc    BuildConfig
+		env   []string
+		flags []string
+	}{
+		{"default", BuildConfig{}, nil, nil},
+		{"platform", BuildConfig{GOOS: "windows", GOARCH: "arm64"}, []string{"GOOS=windows", "GOARCH=arm64"}, nil},
+		{"tags", BuildConfig{Tags: []string{"integration", "e2e"}}, nil, []string{"-tags=integration,e2e"}},
+		{"tests", BuildConfig{Tests: true}, nil, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			env := tc.bc.Env()
+			// the overrides follow the inherited environment, so take precedence
+			if got := env[len(env)-len(tc.env):]; !slices.Equal(got, tc.env) {
+				t.Errorf("Env() ends with %q, want %q", got, tc.env)
+			}
+			if got := tc.bc.BuildFlags(); !slices.Equal(got, tc.flags) {
+				t.Errorf("BuildFlags() = %q, want %q", got, tc.flags)
+			}
+		})
+	}
+}
+
+func TestGetEnclosing(t *testing.T) {
+	const src = `package test
+
+func target(args ...any) any { return nil }
+
+var global = func() { target("global") }
+
+type Server struct{}
+
+func (s *Server) Start() {
+	func() {
+		func() {}()
+		target("first")
+	}()
+	func() {
+		target("second")
+	}()
+	go target("go arg", target("go nested arg"))
+	go func() {
+		target("in goroutine")
+	}()
+	defer target(target("defer arg"))
+	defer func() { target("deferred") }()
+}
+`
+	dir := writeModule(t, map[string]string{"test.go": src})
+	filename := filepath.Join(dir, "test.go")
+
+	tests := []struct {
+		ref     string // argument identifying the call of target
+		chain   string
+		inGo    bool
+		inDefer bool
+	}{
+		{ref: `"global"`, chain: "global scope › func literal #1"},
+		{ref: `"first"`, chain: "(*Server).Start › func literal #1"},
+		{ref: `"second"`, chain: "(*Server).Start › func literal #2"},
+		{ref: `"go arg"`, chain: "(*Server).Start › goroutine", inGo: true},
+		{ref: `"go nested arg"`, chain: "(*Server).Start"},
+		{ref: `"in goroutine"`, chain: "(*Server).Start › goroutine › func literal #3", inGo: true},
+		{ref: `target("defer arg")`, chain: "(*Server).Start › deferred", inDefer: true},
+		{ref: `"defer arg"`, chain: "(*Server).Start"},
+		{ref: `"deferred"`, chain: "(*Server).Start › deferred › func literal #4", inDefer: true},
+	}
+	a := NewASTProcessor()
+	for _, tc := range tests {
+		t.Run(tc.ref, func(t *testing.T) {
+			// the call of target whose argument list starts with ref
+			line, col := position(t, src, "target("+tc.ref, 0)
+			enc, err := a.GetEnclosing(filename, line, col)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(enc.Chain, ChainSeparator); got != tc.chain {
+				t.Errorf("chain = %q, want %q", got, tc.chain)
+			}
+			if enc.InGo != tc.inGo || enc.InDefer != tc.inDefer {
+				t.Errorf("InGo, InDefer = %t, %t, want %t, %t", enc.InGo, enc.InDefer, tc.inGo, tc.inDefer)
+			}
+			if enc.Package != "test" {
+				t.Errorf("Package = %q, want test", enc.Package)
+			}
+		})
+	}
+}
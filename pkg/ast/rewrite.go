@@ -0,0 +1,460 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package ast
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	wildcardPrefix = "__plsdo_"
+	restWildcard   = wildcardPrefix + "rest__"
+)
+
+var (
+	restRe     = regexp.MustCompile(`\$\.\.\.`)
+	wildcardRe = regexp.MustCompile(`\$([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// Rewrite is a structural rewrite rule for call expressions, in the style of gofmt -r.
+//
+// Rules take the form `pattern -> replacement`, eg.
+//
+//	$recv.Info($msg, $...) -> $recv.Infow($msg, $...)
+//
+// $name matches any single expression, and $... matches any remaining call arguments.
+// A wildcard used more than once must match identical expressions each time.
+// Calls spreading a slice, as in f(args...), only match patterns that do the
+// same, or whose final argument is $..., in which case the replacement must also
+// use $... as the final argument of a call so that the slice is still spread.
+type Rewrite struct {
+	pattern     ast.Expr
+	replacement string
+	spreadRest  bool // every use of $... in the replacement can spread a slice
+}
+
+// ParseRewrite parses a rewrite rule.  Every wildcard used by the replacement
+// must be bound by the pattern.
+func ParseRewrite(rule string) (*Rewrite, error) {
+	from, to, found := strings.Cut(rule, "->")
+	if !found {
+		return nil, fmt.Errorf("rewrite rule must be of the form 'pattern -> replacement'")
+	}
+	pattern, err := parser.ParseExpr(expandWildcards(from))
+	if err != nil {
+		return nil, fmt.Errorf("invalid rewrite pattern: %v", err)
+	}
+	if _, ok := pattern.(*ast.CallExpr); !ok {
+		return nil, fmt.Errorf("rewrite pattern must be a call expression")
+	}
+	bound := make(map[string]bool)
+	for _, name := range wildcards(from) {
+		bound[name] = true
+	}
+	for _, name := range wildcards(to) {
+		if !bound[name] {
+			return nil, fmt.Errorf("rewrite replacement uses %s, which is not in the pattern", name)
+		}
+	}
+	replacement, err := parser.ParseExpr(expandWildcards(to))
+	if err != nil {
+		return nil, fmt.Errorf("invalid rewrite replacement: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, token.NewFileSet(), replacement); err != nil {
+		return nil, err
+	}
+	return &Rewrite{pattern: pattern, replacement: buf.String(), spreadRest: canSpreadRest(replacement)}, nil
+}
+
+// canSpreadRest reports whether every use of $... in expr is the final argument
+// of a call that doesn't already spread a slice, so that ... may follow it.
+func canSpreadRest(expr ast.Expr) bool {
+	spreadable := make(map[*ast.Ident]bool)
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && len(call.Args) > 0 && !call.Ellipsis.IsValid() {
+			if id, ok := call.Args[len(call.Args)-1].(*ast.Ident); ok {
+				spreadable[id] = true
+			}
+		}
+		return true
+	})
+	ok := true
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if id, isIdent := n.(*ast.Ident); isIdent && id.Name == restWildcard && !spreadable[id] {
+			ok = false
+		}
+		return ok
+	})
+	return ok
+}
+
+// endsWithRest reports whether a pattern's expression list ends with $...
+func endsWithRest(exprs []ast.Expr) bool {
+	if len(exprs) == 0 {
+		return false
+	}
+	id, ok := exprs[len(exprs)-1].(*ast.Ident)
+	return ok && id.Name == restWildcard
+}
+
+// wildcards returns the wildcards used in part of a rule, eg. $msg and $...
+func wildcards(s string) []string {
+	names := restRe.FindAllString(s, -1)
+	for _, m := range wildcardRe.FindAllStringSubmatch(s, -1) {
+		names = append(names, m[0])
+	}
+	return names
+}
+
+func expandWildcards(s string) string {
+	s = restRe.ReplaceAllString(s, restWildcard)
+	return wildcardRe.ReplaceAllString(s, wildcardPrefix+"$1")
+}
+
+// Position identifies a reference within a file, using 1-based indexing.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// Skipped is a reference that RewriteFile could not rewrite.
+type Skipped struct {
+	Position
+	Reason string
+}
+
+// RewriteFile applies the rule to the calls corresponding to the supplied references
+// within a file, returning the reformatted source and the number of calls rewritten.
+//
+// Only calls of the referenced function are rewritten; calls that don't match the
+// rule's pattern are left untouched, and declarations are ignored.  References
+// that can't be rewritten are returned as skipped: those that aren't called, such
+// as a method value passed to another call, and matching calls nested within
+// another rewritten call other than within one of its wildcards.  A call spreading
+// a slice into a $... that the replacement can't spread is also skipped.
+func (a *ASTProcessor) RewriteFile(filePath string, rule *Rewrite, refs []Position) (result []byte, count int, skipped []Skipped, err error) {
+	if err := a.ParseFile(filePath); err != nil {
+		return nil, 0, nil, err
+	}
+	src := a.srcMap[filePath]
+	file := a.fileMap[filePath]
+
+	var matches []*rewriteMatch
+	seen := make(map[*ast.CallExpr]bool)
+	for _, ref := range refs {
+		position := a.getPosition(filePath, ref.Line, ref.Column)
+		if position == token.NoPos {
+			return nil, 0, nil, fmt.Errorf("invalid position %s:%d:%d", filePath, ref.Line, ref.Column)
+		}
+		call := findCalleeCall(file, position)
+		if call == nil {
+			if !isDeclaration(file, position) {
+				skipped = append(skipped, Skipped{Position: ref, Reason: "not called"})
+			}
+			continue
+		}
+		if seen[call] {
+			continue
+		}
+		seen[call] = true
+
+		m := &rewriteMatch{
+			fset:     a.fset,
+			src:      src,
+			ref:      ref,
+			span:     span{a.fset.Position(call.Pos()).Offset, a.fset.Position(call.End()).Offset},
+			bindings: make(map[string][]span),
+		}
+		if !m.match(reflect.ValueOf(rule.pattern), reflect.ValueOf(call)) {
+			continue
+		}
+		if m.spread && !rule.spreadRest {
+			skipped = append(skipped, Skipped{Position: ref, Reason: "spread variadic arguments can't be passed on by the replacement"})
+			continue
+		}
+		matches = append(matches, m)
+	}
+	if len(matches) == 0 {
+		return src, 0, skipped, nil
+	}
+
+	// rewrite the outermost matches, substituting the rewritten form of any
+	// match nested within one of their wildcards
+	r := &rewriter{src: src, matches: matches, replacement: rule.replacement}
+	result = []byte(r.render(span{0, len(src)}))
+	for _, m := range matches {
+		if m.applied {
+			count++
+		} else {
+			skipped = append(skipped, Skipped{Position: m.ref, Reason: "nested within another rewritten call"})
+		}
+	}
+	slices.SortFunc(skipped, func(x, y Skipped) int {
+		return cmp.Or(cmp.Compare(x.Line, y.Line), cmp.Compare(x.Column, y.Column))
+	})
+
+	formatted, err := format.Source(result)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("rewritten %s does not parse: %v", filePath, err)
+	}
+	return formatted, count, skipped, nil
+}
+
+// isDeclaration reports whether the position is within the name of a function,
+// method or interface method declaration.
+func isDeclaration(file *ast.File, position token.Pos) bool {
+	found := false
+	ast.Inspect(file, func(n ast.Node) bool {
+		if found || n == nil || position < n.Pos() || position > n.End() {
+			return false
+		}
+		var names []*ast.Ident
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			names = []*ast.Ident{n.Name}
+		case *ast.Field:
+			if _, ok := n.Type.(*ast.FuncType); ok {
+				names = n.Names
+			}
+		}
+		for _, name := range names {
+			if name.Pos() <= position && position <= name.End() {
+				found = true
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// span is a range of byte offsets within a source file.
+type span struct {
+	start, end int
+}
+
+func (s span) contains(o span) bool {
+	return s.start <= o.start && o.end <= s.end
+}
+
+// rewriter applies the matches of a rule to a file's source.
+type rewriter struct {
+	src         []byte
+	matches     []*rewriteMatch
+	replacement string
+}
+
+// render returns the source of the span with the outermost matches within it
+// rewritten.
+func (r *rewriter) render(s span) string {
+	var outer []*rewriteMatch
+	for _, m := range r.matches {
+		if !s.contains(m.span) {
+			continue
+		}
+		nested := false
+		for _, o := range r.matches {
+			if o != m && s.contains(o.span) && o.span.contains(m.span) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			outer = append(outer, m)
+		}
+	}
+	slices.SortFunc(outer, func(x, y *rewriteMatch) int { return x.span.start - y.span.start })
+
+	var b strings.Builder
+	pos := s.start
+	for _, m := range outer {
+		b.Write(r.src[pos:m.span.start])
+		b.WriteString(m.substitute(r.replacement, r.render))
+		m.applied = true
+		pos = m.span.end
+	}
+	b.Write(r.src[pos:s.end])
+	return b.String()
+}
+
+// rewriteMatch holds the wildcard bindings of a pattern matched against a call.
+type rewriteMatch struct {
+	fset     *token.FileSet
+	src      []byte
+	ref      Position
+	span     span              // the matched call
+	bindings map[string][]span // expressions bound to each wildcard
+	spread   bool              // the final expression bound to $... is spread, as in f(args...)
+	applied  bool              // set once the call has been rewritten
+}
+
+var (
+	identType    = reflect.TypeOf((*ast.Ident)(nil))
+	callType     = reflect.TypeOf(ast.CallExpr{})
+	objectType   = reflect.TypeOf((*ast.Object)(nil))
+	scopeType    = reflect.TypeOf((*ast.Scope)(nil))
+	positionType = reflect.TypeOf(token.NoPos)
+	exprsType    = reflect.TypeOf([]ast.Expr(nil))
+)
+
+// nodeSpan returns the range of the source code of a node.
+func (m *rewriteMatch) nodeSpan(n ast.Node) span {
+	return span{m.fset.Position(n.Pos()).Offset, m.fset.Position(n.End()).Offset}
+}
+
+// source returns the original source code of a span.
+func (m *rewriteMatch) source(s span) string {
+	return string(m.src[s.start:s.end])
+}
+
+// match reports whether the pattern matches val, recording wildcard bindings.
+// It is modelled on the matcher used by gofmt -r.
+func (m *rewriteMatch) match(pattern, val reflect.Value) bool {
+	if pattern.IsValid() && pattern.Type() == identType {
+		if name := pattern.Interface().(*ast.Ident).Name; strings.HasPrefix(name, wildcardPrefix) && val.IsValid() {
+			expr, ok := val.Interface().(ast.Expr)
+			if !ok || reflect.ValueOf(expr).IsNil() {
+				return false
+			}
+			s := m.nodeSpan(expr)
+			if prev, bound := m.bindings[name]; bound {
+				return normalize(m.source(prev[0])) == normalize(m.source(s))
+			}
+			m.bindings[name] = []span{s}
+			return true
+		}
+	}
+
+	if !pattern.IsValid() || !val.IsValid() {
+		return !pattern.IsValid() && !val.IsValid()
+	}
+	if pattern.Type() != val.Type() {
+		return false
+	}
+	if pattern.Type() == callType && !m.matchEllipsis(pattern.Interface().(ast.CallExpr), val.Interface().(ast.CallExpr)) {
+		return false
+	}
+
+	switch pattern.Type() {
+	case identType:
+		p, v := pattern.Interface().(*ast.Ident), val.Interface().(*ast.Ident)
+		if p == nil || v == nil {
+			return p == v
+		}
+		return p.Name == v.Name
+	case objectType, scopeType, positionType:
+		return true
+	case exprsType:
+		return m.matchExprs(pattern.Interface().([]ast.Expr), val.Interface().([]ast.Expr))
+	}
+
+	switch pattern.Kind() {
+	case reflect.Slice:
+		if pattern.Len() != val.Len() {
+			return false
+		}
+		for i := 0; i < pattern.Len(); i++ {
+			if !m.match(pattern.Index(i), val.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < pattern.NumField(); i++ {
+			if !m.match(pattern.Field(i), val.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Interface, reflect.Pointer:
+		if pattern.IsNil() || val.IsNil() {
+			return pattern.IsNil() && val.IsNil()
+		}
+		return m.match(pattern.Elem(), val.Elem())
+	}
+	return pattern.Interface() == val.Interface()
+}
+
+// matchEllipsis reports whether the calls agree on spreading a slice as their
+// final argument, which positions are otherwise ignored by match.  A spread
+// argument may be bound by a trailing $... in the pattern.
+func (m *rewriteMatch) matchEllipsis(pattern, val ast.CallExpr) bool {
+	if pattern.Ellipsis.IsValid() == val.Ellipsis.IsValid() {
+		return true
+	}
+	if pattern.Ellipsis.IsValid() || !endsWithRest(pattern.Args) || len(val.Args) < len(pattern.Args) {
+		return false
+	}
+	m.spread = true
+	return true
+}
+
+// matchExprs matches expression lists such as call arguments, where a trailing $...
+// in the pattern matches any remaining expressions.
+func (m *rewriteMatch) matchExprs(pattern, val []ast.Expr) bool {
+	if endsWithRest(pattern) {
+		n := len(pattern)
+		if len(val) < n-1 {
+			return false
+		}
+		rest := []span{}
+		for _, expr := range val[n-1:] {
+			rest = append(rest, m.nodeSpan(expr))
+		}
+		m.bindings[restWildcard] = rest
+		pattern, val = pattern[:n-1], val[:n-1]
+	}
+	if len(pattern) != len(val) {
+		return false
+	}
+	for i := range pattern {
+		if !m.match(reflect.ValueOf(pattern[i]), reflect.ValueOf(val[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	restArgRe     = regexp.MustCompile(`,\s*` + restWildcard)
+	placeholderRe = regexp.MustCompile(wildcardPrefix + `[A-Za-z0-9_]+`)
+)
+
+// substitute replaces wildcards in the replacement with their bound source code,
+// as returned by render, spreading the arguments bound to $... if the call did.
+func (m *rewriteMatch) substitute(replacement string, render func(span) string) string {
+	if len(m.bindings[restWildcard]) == 0 {
+		replacement = restArgRe.ReplaceAllString(replacement, "")
+	}
+	return placeholderRe.ReplaceAllStringFunc(replacement, func(name string) string {
+		spans, ok := m.bindings[name]
+		if !ok {
+			return name
+		}
+		texts := make([]string, len(spans))
+		for i, s := range spans {
+			texts[i] = render(s)
+		}
+		if name == restWildcard && m.spread {
+			return strings.Join(texts, ", ") + "..."
+		}
+		return strings.Join(texts, ", ")
+	})
+}
+
+// normalize strips whitespace so that equivalent expressions compare equal.
+func normalize(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
@@ -0,0 +1,172 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package ast
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRewrite(t *testing.T) {
+	tests := []struct {
+		rule    string
+		wantErr string
+	}{
+		{rule: "$recv.Info($msg, $...) -> $recv.Infow($msg, $...)"},
+		{rule: "log.Printf($...) -> log.Print()"},
+		{rule: "f($x, $x) -> g($x)"},
+		{rule: "$recv.Info($msg)", wantErr: "must be of the form"},
+		{rule: "$x + 1 -> $x", wantErr: "must be a call expression"},
+		{rule: "f($x -> g($x)", wantErr: "invalid rewrite pattern"},
+		{rule: "f($x) -> g($x", wantErr: "invalid rewrite replacement"},
+		{rule: "f($x) -> g($x, $y)", wantErr: "uses $y, which is not in the pattern"},
+		{rule: "f($x) -> g($x, $...)", wantErr: "uses $..., which is not in the pattern"},
+	}
+	for _, tc := range tests {
+		_, err := ParseRewrite(tc.rule)
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("ParseRewrite(%q) failed: %v", tc.rule, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("ParseRewrite(%q) returned error %v, want %q", tc.rule, err, tc.wantErr)
+		}
+	}
+}
+
+func TestRewriteFile(t *testing.T) {
+	const header = "package test\n\nfunc Info(args ...any) any { return nil }\n\nfunc run(f func(...any) any) {}\n\n"
+	tests := []struct {
+		name      string
+		rule      string
+		body      string
+		want      string
+		wantCount int
+		wantSkips []string // reasons
+	}{
+		{
+			name:      "rest arguments",
+			rule:      "Info($msg, $...) -> Infow($msg, $...)",
+			body:      `func f() { Info("a", 1, 2) }`,
+			want:      `func f() { Infow("a", 1, 2) }`,
+			wantCount: 1,
+		},
+		{
+			name:      "empty rest",
+			rule:      "Info($msg, $...) -> Infow($msg, $...)",
+			body:      `func f() { Info("a") }`,
+			want:      `func f() { Infow("a") }`,
+			wantCount: 1,
+		},
+		{
+			name:      "repeated wildcard must match",
+			rule:      "Info($x, $x) -> Info($x)",
+			body:      "func f() {\n\tInfo(1, 1)\n\tInfo(1, 2)\n}",
+			want:      "func f() {\n\tInfo(1)\n\tInfo(1, 2)\n}",
+			wantCount: 1,
+		},
+		{
+			name:      "pattern not matched",
+			rule:      "Info($x) -> Infow($x)",
+			body:      "func f() { Info(1, 2) }",
+			want:      "func f() { Info(1, 2) }",
+			wantCount: 0,
+		},
+		{
+			name:      "nested within wildcard",
+			rule:      "Info($x) -> Wrap($x)",
+			body:      "func f() { Info(Info(1)) }",
+			want:      "func f() { Wrap(Wrap(1)) }",
+			wantCount: 2,
+		},
+		{
+			name:      "nested within discarded wildcard",
+			rule:      "Info($x, $y) -> Wrap($x)",
+			body:      "func f() { Info(1, Info(2, 3)) }",
+			want:      "func f() { Wrap(1) }",
+			wantCount: 1,
+			wantSkips: []string{"nested within another rewritten call"},
+		},
+		{
+			name:      "spread rest arguments",
+			rule:      "Info($msg, $...) -> Infow($msg, $...)",
+			body:      "func f(args []any) {\n\tInfo(\"a\", args...)\n\tInfo(\"a\", 1, args...)\n}",
+			want:      "func f(args []any) {\n\tInfow(\"a\", args...)\n\tInfow(\"a\", 1, args...)\n}",
+			wantCount: 2,
+		},
+		{
+			name:      "spread argument not bound by rest",
+			rule:      "Info($msg, $args) -> Infow($msg, $args)",
+			body:      "func f(args []any) { Info(\"a\", args...) }",
+			want:      "func f(args []any) { Info(\"a\", args...) }",
+			wantCount: 0,
+		},
+		{
+			name:      "spread matched by spread pattern",
+			rule:      "Info($msg, $args...) -> Infow($msg, $args...)",
+			body:      "func f(args []any) {\n\tInfo(\"a\", args...)\n\tInfo(\"a\", args)\n}",
+			want:      "func f(args []any) {\n\tInfow(\"a\", args...)\n\tInfo(\"a\", args)\n}",
+			wantCount: 1,
+		},
+		{
+			name:      "spread rest not final in replacement",
+			rule:      "Info($msg, $...) -> Infow([]any{$...}, $msg)",
+			body:      "func f(args []any) {\n\tInfo(\"a\", args...)\n\tInfo(\"a\", 1)\n}",
+			want:      "func f(args []any) {\n\tInfo(\"a\", args...)\n\tInfow([]any{1}, \"a\")\n}",
+			wantCount: 1,
+			wantSkips: []string{"spread variadic arguments can't be passed on by the replacement"},
+		},
+		{
+			name:      "method value is not the callee",
+			rule:      "$f($...) -> Wrap($...)",
+			body:      "func f() { run(Info) }",
+			want:      "func f() { run(Info) }",
+			wantCount: 0,
+			wantSkips: []string{"not called"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, err := ParseRewrite(tc.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src := header + tc.body + "\n"
+			filename := filepath.Join(t.TempDir(), "test.go")
+			if err := os.WriteFile(filename, []byte(src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			// reference every use of Info, including the declaration
+			var refs []Position
+			for n := 0; n < strings.Count(src, "Info("); n++ {
+				line, col := position(t, src, "Info(", n)
+				refs = append(refs, Position{Line: line, Column: col})
+			}
+			if strings.Contains(src, "Info)") {
+				line, col := position(t, src, "Info)", 0)
+				refs = append(refs, Position{Line: line, Column: col})
+			}
+
+			result, count, skipped, err := NewASTProcessor().RewriteFile(filename, rule, refs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimPrefix(string(result), header); got != tc.want+"\n" {
+				t.Errorf("rewrote to:\n%s\nwant:\n%s", got, tc.want)
+			}
+			if count != tc.wantCount {
+				t.Errorf("count = %d, want %d", count, tc.wantCount)
+			}
+			var reasons []string
+			for _, skip := range skipped {
+				reasons = append(reasons, skip.Reason)
+			}
+			if strings.Join(reasons, ";") != strings.Join(tc.wantSkips, ";") {
+				t.Errorf("skipped %q, want %q", reasons, tc.wantSkips)
+			}
+		})
+	}
+}
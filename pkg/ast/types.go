@@ -0,0 +1,221 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package ast
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+
+	"github.com/ryanuber/go-glob"
+	"golang.org/x/tools/go/packages"
+)
+
+// Instance describes the instantiation of a generic function, or of the generic
+// receiver type of a method, at a reference site.
+type Instance struct {
+	Name     string // base name of the generic function or receiver type
+	TypeArgs []types.Type
+}
+
+// String renders the instance with package-qualified type arguments,
+// eg. Cache[string, *models.User]
+func (i Instance) String() string {
+	return i.format(func(p *types.Package) string { return p.Name() })
+}
+
+// Short renders the instance with unqualified type arguments, eg. Cache[string, *User]
+func (i Instance) Short() string {
+	return i.format(func(p *types.Package) string { return "" })
+}
+
+// Match reports whether the instance matches pattern, eg. Cache[string, *User].
+// The name is matched as a glob, while each type argument must equal either the
+// qualified or unqualified form of the instance's, or be * to match any type, so
+// pointer types are not mistaken for wildcards.  A pattern without type arguments
+// matches every instantiation.  Whitespace is ignored.
+func (i Instance) Match(pattern string) bool {
+	pattern = strings.Join(strings.Fields(pattern), "")
+	name, args, found := strings.Cut(pattern, "[")
+	if !glob.Glob(name, i.Name) {
+		return false
+	}
+	if !found {
+		return true
+	}
+	args, ok := strings.CutSuffix(args, "]")
+	if !ok {
+		return false
+	}
+	argPatterns := splitTypeArgs(args)
+	if len(argPatterns) != len(i.TypeArgs) {
+		return false
+	}
+	qualified := func(p *types.Package) string { return p.Name() }
+	unqualified := func(p *types.Package) string { return "" }
+	for n, arg := range i.TypeArgs {
+		want := argPatterns[n]
+		if want == "*" {
+			continue
+		}
+		if want != compactTypeString(arg, qualified) && want != compactTypeString(arg, unqualified) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitTypeArgs splits a list of type arguments at the commas not nested within
+// brackets, parentheses or braces.
+func splitTypeArgs(s string) []string {
+	var args []string
+	depth, start := 0, 0
+	for n, r := range s {
+		switch r {
+		case '[', '(', '{':
+			depth++
+		case ']', ')', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, s[start:n])
+				start = n + 1
+			}
+		}
+	}
+	return append(args, s[start:])
+}
+
+// compactTypeString renders t without whitespace.
+func compactTypeString(t types.Type, qf types.Qualifier) string {
+	return strings.Join(strings.Fields(types.TypeString(t, qf)), "")
+}
+
+func (i Instance) format(qf types.Qualifier) string {
+	args := make([]string, len(i.TypeArgs))
+	for n, arg := range i.TypeArgs {
+		args[n] = types.TypeString(arg, qf)
+	}
+	return i.Name + "[" + strings.Join(args, ", ") + "]"
+}
+
+// TypePackage returns the type-checked package containing filePath, which may be
+// a test file whatever the build configuration.  Every file of a loaded package
+// is cached, so sibling files don't trigger a reload.
+func (a *ASTProcessor) TypePackage(filePath string) (*packages.Package, error) {
+	if pkg, ok := a.typeMap[filePath]; ok {
+		return pkg, nil
+	}
+	cfg := a.packagesConfig(packages.NeedName | packages.NeedFiles | packages.NeedModule |
+		packages.NeedImports | packages.NeedDeps | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo)
+	cfg.Dir = filepath.Dir(filePath)
+	cfg.Tests = true // references in test files are always reported
+	pkgs, err := packages.Load(cfg, "file="+filePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range pkgs {
+		for _, file := range append(pkg.GoFiles, pkg.CompiledGoFiles...) {
+			// prefer the test variant of a package, which includes all of its files
+			if cur, ok := a.typeMap[file]; !ok || len(pkg.CompiledGoFiles) > len(cur.CompiledGoFiles) {
+				a.typeMap[file] = pkg
+			}
+		}
+	}
+	pkg, ok := a.typeMap[filePath]
+	if !ok {
+		return nil, fmt.Errorf("no package found for %s", filePath)
+	}
+	return pkg, nil
+}
+
+// FindIdent returns the identifier at the supplied position, along with the
+// type-checked package containing it.
+func (a *ASTProcessor) FindIdent(filePath string, line, character int) (*ast.Ident, *packages.Package, error) {
+	pkg, err := a.TypePackage(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pkg.TypesInfo == nil {
+		return nil, nil, fmt.Errorf("no type information for %s", filePath)
+	}
+	file := syntaxFile(pkg, filePath)
+	if file == nil {
+		return nil, nil, fmt.Errorf("file %s not found in package %s", filePath, pkg.PkgPath)
+	}
+	tf := pkg.Fset.File(file.Pos())
+	if line < 1 || line > tf.LineCount() {
+		return nil, nil, fmt.Errorf("invalid position")
+	}
+	position := tf.LineStart(line) + token.Pos(character-1)
+
+	var ident *ast.Ident
+	ast.Inspect(file, func(n ast.Node) bool {
+		if ident != nil || n == nil || position < n.Pos() || position >= n.End() {
+			return false
+		}
+		if id, ok := n.(*ast.Ident); ok {
+			ident = id
+		}
+		return true
+	})
+	if ident == nil {
+		return nil, nil, fmt.Errorf("no identifier found at %s:%d:%d", filePath, line, character)
+	}
+	return ident, pkg, nil
+}
+
+// Instantiation returns the generic instantiation referenced at the supplied position.
+// ok is false if the reference is not to a generic function or a method of a generic type.
+func (a *ASTProcessor) Instantiation(filePath string, line, character int) (inst Instance, ok bool, err error) {
+	ident, pkg, err := a.FindIdent(filePath, line, character)
+	if err != nil {
+		return inst, false, err
+	}
+	info := pkg.TypesInfo
+
+	// generic function call
+	if ti, found := info.Instances[ident]; found && ti.TypeArgs.Len() > 0 {
+		return Instance{Name: ident.Name, TypeArgs: typeList(ti.TypeArgs)}, true, nil
+	}
+
+	// method on an instantiated generic type
+	fn, isFunc := info.Uses[ident].(*types.Func)
+	if !isFunc {
+		return inst, false, nil
+	}
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return inst, false, nil
+	}
+	recvType := recv.Type()
+	if ptr, isPtr := recvType.(*types.Pointer); isPtr {
+		recvType = ptr.Elem()
+	}
+	named, isNamed := recvType.(*types.Named)
+	if !isNamed || named.TypeArgs().Len() == 0 {
+		return inst, false, nil
+	}
+	return Instance{Name: named.Obj().Name(), TypeArgs: typeList(named.TypeArgs())}, true, nil
+}
+
+func syntaxFile(pkg *packages.Package, filePath string) *ast.File {
+	for _, file := range pkg.Syntax {
+		if pkg.Fset.Position(file.Pos()).Filename == filePath {
+			return file
+		}
+	}
+	return nil
+}
+
+func typeList(tl *types.TypeList) []types.Type {
+	result := make([]types.Type, tl.Len())
+	for i := range result {
+		result[i] = tl.At(i)
+	}
+	return result
+}
@@ -0,0 +1,50 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package ast
+
+import (
+	"go/types"
+	"testing"
+)
+
+func TestInstanceMatch(t *testing.T) {
+	models := types.NewPackage("example.com/models", "models")
+	named := func(name string) types.Type {
+		return types.NewNamed(types.NewTypeName(0, models, name, nil), types.NewStruct(nil, nil), nil)
+	}
+	str := types.Typ[types.String]
+	inst := Instance{Name: "Cache", TypeArgs: []types.Type{str, types.NewPointer(named("User"))}}
+
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{"Cache[string, *User]", true},
+		{"Cache[string,*models.User]", true},
+		{"Cache[ string , *User ]", true},
+		{"Cache[string, User]", false},
+		{"Cache[string, *AdminUser]", false},
+		{"Cache[string, *]", true},
+		{"Cache[*, *]", true},
+		{"Cache[string]", false},
+		{"Cache[string, *User, int]", false},
+		{"Cache", true},
+		{"C*[string, *User]", true},
+		{"Store[string, *User]", false},
+		{"Cache[string, *User", false},
+	}
+	for _, tc := range tests {
+		if got := inst.Match(tc.pattern); got != tc.want {
+			t.Errorf("Match(%q) = %t, want %t", tc.pattern, got, tc.want)
+		}
+	}
+
+	// commas within a type argument don't split it
+	params := types.NewTuple(types.NewVar(0, nil, "", str), types.NewVar(0, nil, "", str))
+	fn := types.NewSignatureType(nil, nil, nil, params, nil, false)
+	nested := Instance{Name: "Pair", TypeArgs: []types.Type{fn, str}}
+	if !nested.Match("Pair[func(string, string), string]") {
+		t.Errorf("Match of nested type arguments failed for %s", nested.Short())
+	}
+}
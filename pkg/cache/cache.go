@@ -0,0 +1,454 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+
+// Package cache stores query results on disk, invalidating them as the Go
+// source files they were derived from change.
+//
+// Each entry records a snapshot of the content hashes of every .go file and
+// module file beneath the workspace root at the time it was stored.  When the
+// entry is read, the snapshot is compared with the current files and the entry is
+// only discarded if a change could affect it: a change to one of the files it
+// depends on, a file added to or removed from one of its directories, a changed
+// file that contains one of the identifiers it was derived from, or a change to
+// the go.mod, go.sum or go.work files of any module in the workspace.
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gwatts/plsdo/pkg/gofiles"
+)
+
+// formatVersion is incremented whenever the layout of cache files changes.
+const formatVersion = 1
+
+// snapshotTTL is the age after which unused snapshots and entries are removed.
+const snapshotTTL = 7 * 24 * time.Hour
+
+// Dependencies describes what a cached value was derived from, and therefore
+// which changes invalidate it.
+type Dependencies struct {
+	Files   []string // absolute paths of files whose change invalidates the value
+	Dirs    []string // directories in which an added or removed .go file invalidates the value
+	NewDirs bool     // the value is invalidated by .go files in a new directory
+	Names   []string // identifiers whose presence in a changed file invalidates the value
+}
+
+type entry struct {
+	Version  int               `json:"version"`
+	Snapshot string            `json:"snapshot"`
+	Deps     Dependencies      `json:"deps"`
+	External map[string]string `json:"external,omitempty"` // hashes of Deps.Files outside the root
+	Value    json.RawMessage   `json:"value"`
+}
+
+// fileState records the content hash of a file, and the size and modification
+// time used to avoid rehashing unchanged files.
+type fileState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"hash"`
+}
+
+type snapshot map[string]fileState
+
+// Cache stores values for a workspace root.  It is not safe for concurrent use.
+type Cache struct {
+	dir       string   // cache directory
+	root      string   // absolute workspace root
+	env       string   // hash of the module files and toolchain
+	immutable []string // GOROOT and GOMODCACHE, whose files are not tracked
+	current   snapshot
+	currHash  string
+	saved     bool
+}
+
+// Dir returns the default cache directory.
+func Dir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "plsdo"), nil
+}
+
+// Open returns a cache for the workspace rooted at root, stored beneath dir.
+//
+// Entries are additionally keyed by the go.mod, go.sum and go.work files of root
+// and its parent directories, and by the Go toolchain version, so changes to
+// dependencies invalidate every entry.  Those of modules beneath root, such as
+// the members of a go.work workspace, are tracked with the .go files.
+func Open(dir, root string) (*Cache, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range []string{"entries", "snapshots", "roots"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	h := sha256.New()
+	h.Write([]byte(root + "\x00"))
+	cmd := exec.Command("go", "env", "GOVERSION", "GOFLAGS", "GOWORK", "GOROOT", "GOMODCACHE")
+	cmd.Dir = root
+	goEnv, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	h.Write(goEnv)
+	vars := strings.Split(string(goEnv), "\n")
+	if len(vars) < 5 {
+		return nil, errors.New("unexpected go env output")
+	}
+	for d := root; ; d = filepath.Dir(d) {
+		for _, name := range gofiles.ModuleFiles {
+			if data, err := os.ReadFile(filepath.Join(d, name)); err == nil {
+				h.Write([]byte(filepath.Join(d, name) + "\x00"))
+				h.Write(data)
+			}
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	return &Cache{
+		dir:       dir,
+		root:      root,
+		env:       hex.EncodeToString(h.Sum(nil)),
+		immutable: []string{vars[3], vars[4]},
+	}, nil
+}
+
+// Reset discards the snapshot of the workspace files, so changes made since
+// it was taken are detected.
+func (c *Cache) Reset() {
+	c.current, c.currHash, c.saved = nil, "", false
+}
+
+// Get reads the value stored for key into v, reporting whether a valid entry
+// was found.
+func (c *Cache) Get(key []string, v any) bool {
+	data, err := os.ReadFile(c.entryPath(key))
+	if err != nil {
+		return false
+	}
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil || e.Version != formatVersion {
+		return false
+	}
+	if ok, err := c.valid(e); err != nil || !ok {
+		return false
+	}
+	// refresh the modification time so the entry is not pruned
+	now := time.Now()
+	os.Chtimes(c.entryPath(key), now, now)
+	return json.Unmarshal(e.Value, v) == nil
+}
+
+// Put stores v for key, along with the dependencies that invalidate it.
+func (c *Cache) Put(key []string, v any, deps Dependencies) error {
+	if err := c.scan(); err != nil {
+		return err
+	}
+	if err := c.saveSnapshot(); err != nil {
+		return err
+	}
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	e := entry{
+		Version:  formatVersion,
+		Snapshot: c.currHash,
+		Deps:     deps,
+		Value:    value,
+	}
+	for _, filename := range deps.Files {
+		if gofiles.Within(c.root, filename) || slices.ContainsFunc(c.immutable, func(dir string) bool { return gofiles.Within(dir, filename) }) {
+			continue
+		}
+		hash, err := hashFile(filename)
+		if err != nil {
+			return err
+		}
+		if e.External == nil {
+			e.External = make(map[string]string)
+		}
+		e.External[filename] = hash
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return writeFile(c.entryPath(key), data)
+}
+
+func (c *Cache) entryPath(key []string) string {
+	h := sha256.New()
+	h.Write([]byte(c.env))
+	for _, k := range key {
+		h.Write([]byte("\x00" + k))
+	}
+	return filepath.Join(c.dir, "entries", hex.EncodeToString(h.Sum(nil))+".json")
+}
+
+// valid reports whether no change since the entry was stored affects it.
+func (c *Cache) valid(e entry) (bool, error) {
+	for filename, hash := range e.External {
+		if current, err := hashFile(filename); err != nil || current != hash {
+			return false, nil
+		}
+	}
+	if err := c.scan(); err != nil {
+		return false, err
+	}
+	if e.Snapshot == c.currHash {
+		return true, nil
+	}
+	old, err := c.loadSnapshot(e.Snapshot)
+	if err != nil {
+		return false, nil
+	}
+
+	files := make(map[string]bool)
+	for _, f := range e.Deps.Files {
+		files[f] = true
+	}
+	dirs := make(map[string]bool)
+	for _, d := range e.Deps.Dirs {
+		dirs[d] = true
+	}
+	oldDirs := make(map[string]bool)
+	for f := range old {
+		oldDirs[filepath.Dir(f)] = true
+	}
+
+	for _, f := range changedFiles(old, c.current) {
+		// dependency versions may have changed, moving external files
+		if files[f] || gofiles.IsModuleFile(filepath.Base(f)) {
+			return false, nil
+		}
+		_, existed := old[f]
+		_, exists := c.current[f]
+		if existed != exists && dirs[filepath.Dir(f)] {
+			return false, nil
+		}
+		if exists && !existed && e.Deps.NewDirs && !oldDirs[filepath.Dir(f)] {
+			return false, nil
+		}
+		if exists && len(e.Deps.Names) > 0 {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				return false, nil
+			}
+			for _, name := range e.Deps.Names {
+				if containsIdent(data, name) {
+					return false, nil
+				}
+			}
+		}
+	}
+	return true, nil
+}
+
+// changedFiles returns the files added, removed or modified between two snapshots.
+func changedFiles(old, current snapshot) []string {
+	var changed []string
+	for f, state := range current {
+		if prev, ok := old[f]; !ok || prev.Hash != state.Hash {
+			changed = append(changed, f)
+		}
+	}
+	for f := range old {
+		if _, ok := current[f]; !ok {
+			changed = append(changed, f)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// scan records the content hash of every .go file and module file beneath the
+// root, skipping directories ignored by the go command.  Files whose size and modification time
+// match the previous scan of the root are not rehashed.
+func (c *Cache) scan() error {
+	if c.current != nil {
+		return nil
+	}
+	prev := make(snapshot)
+	if hash, err := os.ReadFile(c.rootPath()); err == nil {
+		if s, err := c.loadSnapshot(strings.TrimSpace(string(hash))); err == nil {
+			prev = s
+		}
+	}
+
+	current := make(snapshot)
+	err := filepath.WalkDir(c.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != c.root && gofiles.IgnoredDir(name) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !gofiles.IsSource(name) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		state := fileState{Size: info.Size(), ModTime: info.ModTime().UTC()}
+		if p, ok := prev[path]; ok && p.Size == state.Size && p.ModTime.Equal(state.ModTime) {
+			state.Hash = p.Hash
+		} else if state.Hash, err = hashFile(path); err != nil {
+			return err
+		}
+		current[path] = state
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(current))
+	for path := range current {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	h := sha256.New()
+	for _, path := range paths {
+		h.Write([]byte(path + "\x00" + current[path].Hash + "\n"))
+	}
+	c.current, c.currHash, c.saved = current, hex.EncodeToString(h.Sum(nil)), false
+	return nil
+}
+
+func (c *Cache) rootPath() string {
+	h := sha256.Sum256([]byte(c.root))
+	return filepath.Join(c.dir, "roots", hex.EncodeToString(h[:]))
+}
+
+func (c *Cache) snapshotPath(hash string) string {
+	return filepath.Join(c.dir, "snapshots", hash+".json")
+}
+
+func (c *Cache) loadSnapshot(hash string) (snapshot, error) {
+	if hash == "" {
+		return nil, errors.New("no snapshot")
+	}
+	data, err := os.ReadFile(c.snapshotPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	var s snapshot
+	return s, json.Unmarshal(data, &s)
+}
+
+// saveSnapshot stores the current snapshot, removing snapshots that have not
+// been used recently.
+func (c *Cache) saveSnapshot() error {
+	if c.saved {
+		return nil
+	}
+	path := c.snapshotPath(c.currHash)
+	if _, err := os.Stat(path); err == nil {
+		// refresh the modification time so it is not pruned
+		now := time.Now()
+		os.Chtimes(path, now, now)
+	} else {
+		data, err := json.Marshal(c.current)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(path, data); err != nil {
+			return err
+		}
+		c.prune()
+	}
+	if err := writeFile(c.rootPath(), []byte(c.currHash)); err != nil {
+		return err
+	}
+	c.saved = true
+	return nil
+}
+
+func (c *Cache) prune() {
+	for _, sub := range []string{"snapshots", "entries"} {
+		dir := filepath.Join(c.dir, sub)
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > snapshotTTL {
+				os.Remove(filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+}
+
+// writeFile atomically replaces filename with data.
+func writeFile(filename string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+func hashFile(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// containsIdent reports whether name appears in data other than as part of a
+// longer identifier.
+func containsIdent(data []byte, name string) bool {
+	for i := 0; ; {
+		j := bytes.Index(data[i:], []byte(name))
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(name)
+		prev, _ := utf8.DecodeLastRune(data[:start])
+		next, _ := utf8.DecodeRune(data[end:])
+		if (start == 0 || !isIdentRune(prev)) && (end == len(data) || !isIdentRune(next)) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
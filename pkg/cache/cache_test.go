@@ -0,0 +1,105 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInvalidation(t *testing.T) {
+	write := func(t *testing.T, path, data string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		deps   Dependencies
+		change func(t *testing.T, root string)
+		valid  bool
+	}{
+		{
+			name:   "unrelated file changed",
+			deps:   Dependencies{Files: []string{"a/a.go"}},
+			change: func(t *testing.T, root string) { write(t, filepath.Join(root, "b/b.go"), "package b\n\nfunc B() {}\n") },
+			valid:  true,
+		},
+		{
+			name: "dependency changed",
+			deps: Dependencies{Files: []string{"a/a.go"}},
+			change: func(t *testing.T, root string) {
+				write(t, filepath.Join(root, "a/a.go"), "package a\n\nfunc A2() {}\n")
+			},
+		},
+		{
+			name: "file added to directory",
+			deps: Dependencies{Dirs: []string{"a"}},
+			change: func(t *testing.T, root string) {
+				write(t, filepath.Join(root, "a/new.go"), "package a\n")
+			},
+		},
+		{
+			name:   "identifier in changed file",
+			deps:   Dependencies{Names: []string{"A"}},
+			change: func(t *testing.T, root string) { write(t, filepath.Join(root, "b/b.go"), "package b\n\nvar x = A\n") },
+		},
+		{
+			name: "member module requirements changed",
+			deps: Dependencies{Files: []string{"a/a.go"}},
+			change: func(t *testing.T, root string) {
+				write(t, filepath.Join(root, "b/go.mod"), "module example.com/b\n\ngo 1.23\n\nrequire example.com/dep v1.2.0\n")
+			},
+		},
+		{
+			name: "member module checksums changed",
+			deps: Dependencies{Files: []string{"a/a.go"}},
+			change: func(t *testing.T, root string) {
+				write(t, filepath.Join(root, "a/go.sum"), "example.com/dep v1.2.0 h1:abc=\n")
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			write(t, filepath.Join(root, "go.work"), "go 1.23\n\nuse (\n\t./a\n\t./b\n)\n")
+			write(t, filepath.Join(root, "a/go.mod"), "module example.com/a\n\ngo 1.23\n")
+			write(t, filepath.Join(root, "a/a.go"), "package a\n\nfunc A() {}\n")
+			write(t, filepath.Join(root, "b/go.mod"), "module example.com/b\n\ngo 1.23\n")
+			write(t, filepath.Join(root, "b/b.go"), "package b\n")
+
+			c, err := Open(t.TempDir(), root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			deps := tc.deps
+			for i, f := range deps.Files {
+				deps.Files[i] = filepath.Join(root, f)
+			}
+			for i, d := range deps.Dirs {
+				deps.Dirs[i] = filepath.Join(root, d)
+			}
+			key := []string{"defs", tc.name}
+			if err := c.Put(key, "value", deps); err != nil {
+				t.Fatal(err)
+			}
+
+			tc.change(t, root)
+			c.Reset()
+			var got string
+			if ok := c.Get(key, &got); ok != tc.valid {
+				t.Errorf("Get after change = %t, want %t", ok, tc.valid)
+			}
+			if tc.valid && got != "value" {
+				t.Errorf("Get returned %q, want value", got)
+			}
+		})
+	}
+}
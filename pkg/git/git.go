@@ -0,0 +1,204 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+
+// Package git runs git to find changed lines and blame information.
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LineRange is an inclusive range of 1-based line numbers.
+type LineRange struct {
+	Start int
+	End   int
+}
+
+// Changes maps absolute filenames to the lines added or modified within them.
+type Changes map[string][]LineRange
+
+// Contains reports whether a line of filename was added or modified.
+func (c Changes) Contains(filename string, line int) bool {
+	for _, r := range c[filename] {
+		if line >= r.Start && line <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// Root returns the top-level directory of the repository containing dir.
+func Root(dir string) (string, error) {
+	root, err := run(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(root), nil
+}
+
+// ChangedSince returns the lines of the repository containing dir that were added
+// or modified since the merge base of rev and HEAD, including uncommitted changes
+// and untracked files.  Passing HEAD as rev returns only the uncommitted changes.
+func ChangedSince(dir, rev string) (Changes, error) {
+	root, err := Root(dir)
+	if err != nil {
+		return nil, err
+	}
+	base, err := run(dir, "merge-base", rev, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	diff, err := run(root, "-c", "core.quotePath=false", "diff", "-U0", "--no-color", "--no-ext-diff", "--no-renames", strings.TrimSpace(base))
+	if err != nil {
+		return nil, err
+	}
+	changes, err := parseDiff(root, diff)
+	if err != nil {
+		return nil, err
+	}
+
+	untracked, err := run(root, "-c", "core.quotePath=false", "ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(untracked, "\x00") {
+		if name != "" {
+			// treat every line of a new file as added
+			changes[filepath.Join(root, name)] = []LineRange{{Start: 1, End: math.MaxInt}}
+		}
+	}
+	return changes, nil
+}
+
+// parseDiff returns the added and modified lines of a unified diff with no context.
+func parseDiff(root, diff string) (Changes, error) {
+	changes := make(Changes)
+	var filename string
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			name := strings.TrimPrefix(line, "+++ ")
+			if strings.HasPrefix(name, `"`) {
+				unquoted, err := strconv.Unquote(name)
+				if err != nil {
+					return nil, fmt.Errorf("invalid diff filename %s", name)
+				}
+				name = unquoted
+			}
+			filename = ""
+			if rel, ok := strings.CutPrefix(name, "b/"); ok {
+				filename = filepath.Join(root, filepath.FromSlash(rel))
+			}
+		case strings.HasPrefix(line, "@@ ") && filename != "":
+			// @@ -old[,count] +new[,count] @@
+			fields := strings.Fields(line)
+			if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+				return nil, fmt.Errorf("invalid diff hunk %q", line)
+			}
+			start, count, err := parseHunkRange(fields[2][1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid diff hunk %q", line)
+			}
+			if count > 0 {
+				changes[filename] = append(changes[filename], LineRange{Start: start, End: start + count - 1})
+			}
+		}
+	}
+	return changes, scanner.Err()
+}
+
+func parseHunkRange(s string) (start, count int, err error) {
+	startStr, countStr, ok := strings.Cut(s, ",")
+	if start, err = strconv.Atoi(startStr); err != nil {
+		return 0, 0, err
+	}
+	count = 1
+	if ok {
+		if count, err = strconv.Atoi(countStr); err != nil {
+			return 0, 0, err
+		}
+	}
+	return start, count, nil
+}
+
+// BlameLine identifies the commit that last modified a line.
+type BlameLine struct {
+	Commit      string
+	Author      string
+	AuthorEmail string
+	Time        time.Time
+}
+
+// Committed reports whether the line has been committed.
+func (b BlameLine) Committed() bool {
+	return strings.Trim(b.Commit, "0") != ""
+}
+
+// Blame returns the blame information for each line of filename, indexed by
+// line number - 1.  Files that are not tracked by git return no information.
+func Blame(filename string) ([]BlameLine, error) {
+	out, err := run(filepath.Dir(filename), "blame", "--line-porcelain", "--", filepath.Base(filename))
+	if err != nil {
+		if strings.Contains(err.Error(), "no such path") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var lines []BlameLine
+	var current BlameLine
+	header := true
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "\t"):
+			// the content of the line ends each entry
+			lines = append(lines, current)
+			header = true
+		case header:
+			commit, _, _ := strings.Cut(line, " ")
+			current = BlameLine{Commit: commit}
+			header = false
+		default:
+			key, value, _ := strings.Cut(line, " ")
+			switch key {
+			case "author":
+				current.Author = value
+			case "author-mail":
+				current.AuthorEmail = strings.Trim(value, "<>")
+			case "author-time":
+				if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+					current.Time = time.Unix(secs, 0).UTC()
+				}
+			}
+		}
+	}
+	return lines, nil
+}
+
+// run runs git in dir, returning its output.
+func run(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), msg)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,44 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+
+// Package gofiles decides which files and directories beneath a module affect
+// its packages, so the cache and the file watcher agree on what to scan.
+package gofiles
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ModuleFiles are the files recording module dependencies.
+var ModuleFiles = []string{"go.mod", "go.sum", "go.work", "go.work.sum"}
+
+// IsModuleFile reports whether name is that of a file recording module
+// dependencies, such as go.mod.
+func IsModuleFile(name string) bool {
+	return slices.Contains(ModuleFiles, name)
+}
+
+// IsSource reports whether a file called name may affect the packages of a
+// module: a .go file or a module file.
+func IsSource(name string) bool {
+	return strings.HasSuffix(name, ".go") || IsModuleFile(name)
+}
+
+// IgnoredDir reports whether the go command ignores directories called name when
+// matching packages: those starting with "." or "_", and testdata.
+func IgnoredDir(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata"
+}
+
+// Within reports whether filename is dir or beneath it.  An empty dir contains
+// nothing.
+func Within(dir, filename string) bool {
+	if dir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(dir, filename)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,69 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package gofiles
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWithin(t *testing.T) {
+	tests := []struct {
+		dir, filename string
+		want          bool
+	}{
+		{"/repo/app", "/repo/app", true},
+		{"/repo/app", "/repo/app/main.go", true},
+		{"/repo/app", "/repo/app/sub/main.go", true},
+		{"/repo/app", "/repo/app/..x/main.go", true},
+		{"/repo/app", "/repo/app2/main.go", false},
+		{"/repo/app", "/repo/main.go", false},
+		{"/repo/app", "/repo", false},
+		{"/repo/app", "/other/app/main.go", false},
+		{"", "main.go", false},
+	}
+	for _, tc := range tests {
+		if got := Within(filepath.FromSlash(tc.dir), filepath.FromSlash(tc.filename)); got != tc.want {
+			t.Errorf("Within(%s, %s) = %v, want %v", tc.dir, tc.filename, got, tc.want)
+		}
+	}
+}
+
+func TestIgnoredDir(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{".git", true},
+		{"_build", true},
+		{"testdata", true},
+		{"pkg", false},
+		{"vendor", false},
+		{"data", false},
+	}
+	for _, tc := range tests {
+		if got := IgnoredDir(tc.name); got != tc.want {
+			t.Errorf("IgnoredDir(%s) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIsSource(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"main.go", true},
+		{"main_test.go", true},
+		{"go.mod", true},
+		{"go.work.sum", true},
+		{"README.md", false},
+		{"go.mod.bak", false},
+	}
+	for _, tc := range tests {
+		if got := IsSource(tc.name); got != tc.want {
+			t.Errorf("IsSource(%s) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
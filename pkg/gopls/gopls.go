@@ -28,25 +28,51 @@ type Match struct {
 	EndCharacter   int // 1-based indexing
 }
 
-// GoplsClient encapsulates communication with the gopls server.
+// Options configures the build settings used by the gopls session.
+type Options struct {
+	BuildFlags       []string          // eg. -tags=integration
+	Env              map[string]string // eg. GOOS, GOARCH
+	WorkspaceFolders []string          // directories of the modules in a go.work workspace
+}
+
+// settings returns the gopls initialization options for the configuration.
+func (o Options) settings() map[string]interface{} {
+	settings := map[string]interface{}{}
+	if len(o.BuildFlags) > 0 {
+		settings["buildFlags"] = o.BuildFlags
+	}
+	if len(o.Env) > 0 {
+		settings["env"] = o.Env
+	}
+	return settings
+}
+
+// GoplsClient encapsulates communication with the gopls server.  It is safe for
+// concurrent use; responses are dispatched to the waiting request by id.
 type GoplsClient struct {
-	cmd      *exec.Cmd
-	stdin    io.WriteCloser
-	stdout   io.ReadCloser
-	reader   *bufio.Reader
-	writer   *bufio.Writer
-	seq      int
-	seqMutex sync.Mutex
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	stdout     io.ReadCloser
+	reader     *bufio.Reader
+	writer     *bufio.Writer
+	writeMutex sync.Mutex
+	seq        int
+	seqMutex   sync.Mutex
+
+	pendingMutex sync.Mutex
+	pending      map[int]chan map[string]interface{} // requests awaiting a response, by id
+	readErr      error                               // set once reading from gopls fails
 }
 
 // NewGoplsClient starts a gopls server and initializes the client.
-func NewGoplsClient(projectRoot string) (*GoplsClient, error) {
+func NewGoplsClient(projectRoot string, opts Options) (*GoplsClient, error) {
 	projectRoot, err := filepath.Abs(projectRoot)
 	if err != nil {
 		return nil, err
 	}
 
 	cmd := exec.Command("gopls", "-remote=auto")
+	cmd.Dir = projectRoot
 	cmd.Stderr = os.Stderr
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
@@ -61,17 +87,17 @@ func NewGoplsClient(projectRoot string) (*GoplsClient, error) {
 	}
 
 	client := &GoplsClient{
-		cmd:      cmd,
-		stdin:    stdin,
-		stdout:   stdout,
-		reader:   bufio.NewReader(stdout),
-		writer:   bufio.NewWriter(stdin),
-		seq:      0,
-		seqMutex: sync.Mutex{},
+		cmd:     cmd,
+		stdin:   stdin,
+		stdout:  stdout,
+		reader:  bufio.NewReader(stdout),
+		writer:  bufio.NewWriter(stdin),
+		pending: make(map[int]chan map[string]interface{}),
 	}
+	go client.readLoop()
 
 	// Initialize the LSP session
-	if err := client.initialize(projectRoot); err != nil {
+	if err := client.initialize(projectRoot, opts); err != nil {
 		return nil, err
 	}
 
@@ -80,28 +106,11 @@ func NewGoplsClient(projectRoot string) (*GoplsClient, error) {
 
 // Close gracefully shuts down the gopls server.
 func (c *GoplsClient) Close() error {
-	// Send shutdown request
-	shutdownRequestID := c.getSeq()
-	shutdownRequest := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      shutdownRequestID,
-		"method":  "shutdown",
-	}
-	if err := c.sendMessage(shutdownRequest); err != nil {
+	// Send shutdown request and wait for the response
+	if _, err := c.call("shutdown", nil); err != nil {
 		return err
 	}
 
-	// Wait for shutdown response
-	for {
-		resp, err := c.readMessage()
-		if err != nil {
-			return err
-		}
-		if id, ok := resp["id"]; ok && int(id.(float64)) == shutdownRequestID {
-			break
-		}
-	}
-
 	// Send exit notification
 	exitNotification := map[string]interface{}{
 		"jsonrpc": "2.0",
@@ -118,69 +127,252 @@ func (c *GoplsClient) Close() error {
 
 // FindReferences finds all references to a symbol defined in a file at a given position.
 func (c *GoplsClient) FindReferences(filename string, line, character int) ([]Match, error) {
-	// Prepare and send the references request
-	referencesRequestID := c.getSeq()
-	referencesRequest := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      referencesRequestID,
-		"method":  "textDocument/references",
-		"params": map[string]interface{}{
-			"textDocument": map[string]interface{}{
-				"uri": pathToURI(filename),
-			},
-			"position": map[string]interface{}{
-				"line":      line - 1,
-				"character": character - 1,
-			},
-			"context": map[string]interface{}{
-				"includeDeclaration": true,
-			},
+	resp, err := c.call("textDocument/references", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri": pathToURI(filename),
 		},
+		"position": map[string]interface{}{
+			"line":      line - 1,
+			"character": character - 1,
+		},
+		"context": map[string]interface{}{
+			"includeDeclaration": true,
+		},
+	})
+	if err != nil {
+		return nil, err
 	}
-	if err := c.sendMessage(referencesRequest); err != nil {
+	return c.parseReferences(resp)
+}
+
+// FindImplementations finds the implementations of an interface method, or the
+// interface methods implemented by a concrete method, at a given position.
+func (c *GoplsClient) FindImplementations(filename string, line, character int) ([]Match, error) {
+	resp, err := c.call("textDocument/implementation", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri": pathToURI(filename),
+		},
+		"position": map[string]interface{}{
+			"line":      line - 1,
+			"character": character - 1,
+		},
+	})
+	if err != nil {
 		return nil, err
 	}
+	if resp["result"] == nil {
+		// no implementations
+		return nil, nil
+	}
+	return c.parseReferences(resp)
+}
 
-	// Read responses and look for the references response
-	for {
-		resp, err := c.readMessage()
+// Symbol is a named declaration found by WorkspaceSymbols.
+type Symbol struct {
+	Name      string // eg. Logger.Info for methods
+	Kind      string // eg. function, method, struct, interface
+	Container string // package path
+	Location  Match
+}
+
+// symbolKinds names the LSP SymbolKind values.
+var symbolKinds = map[int]string{
+	1: "file", 2: "module", 3: "namespace", 4: "package", 5: "class", 6: "method",
+	7: "property", 8: "field", 9: "constructor", 10: "enum", 11: "interface",
+	12: "function", 13: "variable", 14: "constant", 15: "string", 16: "number",
+	17: "boolean", 18: "array", 19: "object", 20: "key", 21: "null",
+	22: "enum member", 23: "struct", 24: "event", 25: "operator", 26: "type parameter",
+}
+
+// WorkspaceSymbols finds the declarations in the workspace matching a query,
+// using the symbol matching configured in gopls (fuzzy by default).
+func (c *GoplsClient) WorkspaceSymbols(query string) ([]Symbol, error) {
+	resp, err := c.call("workspace/symbol", map[string]interface{}{
+		"query": query,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result, ok := resp["result"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+	var symbols []Symbol
+	for _, item := range result {
+		data, err := json.Marshal(item)
 		if err != nil {
 			return nil, err
 		}
-		if id, ok := resp["id"]; ok && int(id.(float64)) == referencesRequestID {
-			// Process and return the references
-			matches, err := c.parseReferences(resp)
-			if err != nil {
-				return nil, err
+		var sym struct {
+			Name          string `json:"name"`
+			Kind          int    `json:"kind"`
+			ContainerName string `json:"containerName"`
+			Location      struct {
+				URI   string `json:"uri"`
+				Range struct {
+					Start struct{ Line, Character int }
+					End   struct{ Line, Character int }
+				} `json:"range"`
+			} `json:"location"`
+		}
+		if err := json.Unmarshal(data, &sym); err != nil {
+			continue
+		}
+		kind, ok := symbolKinds[sym.Kind]
+		if !ok {
+			kind = strconv.Itoa(sym.Kind)
+		}
+		loc := sym.Location
+		symbols = append(symbols, Symbol{
+			Name:      sym.Name,
+			Kind:      kind,
+			Container: sym.ContainerName,
+			Location: Match{
+				URI:            loc.URI,
+				Filename:       uriToPath(loc.URI),
+				StartLine:      loc.Range.Start.Line + 1,
+				StartCharacter: loc.Range.Start.Character + 1,
+				EndLine:        loc.Range.End.Line + 1,
+				EndCharacter:   loc.Range.End.Character + 1,
+			},
+		})
+	}
+	return symbols, nil
+}
+
+// call sends a request and waits for its response, returning an error if gopls
+// reports one.
+func (c *GoplsClient) call(method string, params map[string]interface{}) (map[string]interface{}, error) {
+	requestID := c.getSeq()
+	request := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      requestID,
+		"method":  method,
+	}
+	if params != nil {
+		request["params"] = params
+	}
+
+	ch := make(chan map[string]interface{}, 1)
+	c.pendingMutex.Lock()
+	if err := c.readErr; err != nil {
+		c.pendingMutex.Unlock()
+		return nil, err
+	}
+	c.pending[requestID] = ch
+	c.pendingMutex.Unlock()
+
+	if err := c.sendMessage(request); err != nil {
+		c.pendingMutex.Lock()
+		delete(c.pending, requestID)
+		c.pendingMutex.Unlock()
+		return nil, err
+	}
+
+	resp, ok := <-ch
+	if !ok {
+		c.pendingMutex.Lock()
+		defer c.pendingMutex.Unlock()
+		return nil, c.readErr
+	}
+	if rpcErr, ok := resp["error"].(map[string]interface{}); ok {
+		return nil, fmt.Errorf("%s: %v", method, rpcErr["message"])
+	}
+	return resp, nil
+}
+
+// readLoop reads messages from gopls, passing each response to the request
+// awaiting it.  Notifications and requests from gopls are ignored.
+func (c *GoplsClient) readLoop() {
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			// fail any outstanding and future requests
+			c.pendingMutex.Lock()
+			c.readErr = err
+			for id, ch := range c.pending {
+				close(ch)
+				delete(c.pending, id)
 			}
-			return matches, nil
+			c.pendingMutex.Unlock()
+			return
+		}
+		id, ok := msg["id"].(float64)
+		if !ok || msg["method"] != nil {
+			continue
+		}
+		c.pendingMutex.Lock()
+		ch, ok := c.pending[int(id)]
+		delete(c.pending, int(id))
+		c.pendingMutex.Unlock()
+		if ok {
+			ch <- msg
 		}
 	}
 }
 
-// initialize sets up the LSP session with gopls.
-func (c *GoplsClient) initialize(projectRoot string) error {
-	// Send Initialize request
-	initializeRequest := map[string]interface{}{
+// FileChangeType describes how a watched file changed.
+type FileChangeType int
+
+const (
+	FileCreated FileChangeType = 1
+	FileChanged FileChangeType = 2
+	FileDeleted FileChangeType = 3
+)
+
+// FileEvent describes a change to a file on disk.
+type FileEvent struct {
+	Filename string
+	Type     FileChangeType
+}
+
+// DidChangeWatchedFiles notifies gopls that files have changed on disk, so that
+// subsequent requests reflect their new contents.
+func (c *GoplsClient) DidChangeWatchedFiles(events []FileEvent) error {
+	changes := make([]interface{}, 0, len(events))
+	for _, ev := range events {
+		changes = append(changes, map[string]interface{}{
+			"uri":  pathToURI(ev.Filename),
+			"type": int(ev.Type),
+		})
+	}
+	return c.sendMessage(map[string]interface{}{
 		"jsonrpc": "2.0",
-		"id":      c.getSeq(),
-		"method":  "initialize",
+		"method":  "workspace/didChangeWatchedFiles",
 		"params": map[string]interface{}{
-			"processId": nil,
-			"rootUri":   pathToURI(projectRoot),
-			"capabilities": map[string]interface{}{
-				"textDocument": map[string]interface{}{
-					"references": map[string]interface{}{},
-				},
+			"changes": changes,
+		},
+	})
+}
+
+// initialize sets up the LSP session with gopls.
+func (c *GoplsClient) initialize(projectRoot string, opts Options) error {
+	// Send Initialize request and wait for the response
+	params := map[string]interface{}{
+		"processId":             nil,
+		"rootUri":               pathToURI(projectRoot),
+		"initializationOptions": opts.settings(),
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"references": map[string]interface{}{},
+			},
+			"workspace": map[string]interface{}{
+				"didChangeWatchedFiles": map[string]interface{}{},
+				"workspaceFolders":      len(opts.WorkspaceFolders) > 0,
 			},
 		},
 	}
-	if err := c.sendMessage(initializeRequest); err != nil {
-		return err
+	if len(opts.WorkspaceFolders) > 0 {
+		var folders []interface{}
+		for _, dir := range opts.WorkspaceFolders {
+			folders = append(folders, map[string]interface{}{
+				"uri":  pathToURI(dir),
+				"name": filepath.Base(dir),
+			})
+		}
+		params["workspaceFolders"] = folders
 	}
-
-	// Read Initialize response
-	_, err := c.readMessage()
+	_, err := c.call("initialize", params)
 	if err != nil {
 		return err
 	}
@@ -220,6 +412,8 @@ func (c *GoplsClient) sendMessage(msg map[string]interface{}) error {
 	if err != nil {
 		return err
 	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
 	header := fmt.Sprintf("Content-Length: %d\r\n\r\n", len(data))
 	if _, err := c.writer.WriteString(header); err != nil {
 		return err
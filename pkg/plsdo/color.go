@@ -0,0 +1,233 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"golang.org/x/term"
+)
+
+// Color modes accepted by DetectColor.
+const (
+	ColorAuto   = "auto"   // color if writing to a terminal that supports it
+	ColorAlways = "always" // always color, detecting the depth from the environment
+	ColorNever  = "never"
+)
+
+// ColorDepth is the number of colors used for terminal output.
+type ColorDepth int
+
+const (
+	ColorNone ColorDepth = 0
+	Color8    ColorDepth = 8
+	Color16   ColorDepth = 16
+	Color256  ColorDepth = 256
+	ColorTrue ColorDepth = 1 << 24 // 24-bit color
+)
+
+// highlightRef is the ANSI sequence used to mark the referenced identifier.
+const highlightRef = "\x1b[1;4m"
+
+// DetectColor returns the color depth to use for output written to f.
+//
+// In auto mode color is disabled if f is not a terminal, TERM is "dumb" or NO_COLOR
+// is set, unless FORCE_COLOR is set.  FORCE_COLOR may also select a depth: 1 for
+// basic colors, 2 for 256 colors or 3 for 24-bit color.  Otherwise the depth is
+// taken from COLORTERM and TERM.
+func DetectColor(mode string, f *os.File) (ColorDepth, error) {
+	force, forced := os.LookupEnv("FORCE_COLOR")
+	if forced && (force == "0" || force == "false") {
+		return ColorNone, nil
+	}
+	switch mode {
+	case ColorNever:
+		return ColorNone, nil
+	case ColorAlways:
+	case ColorAuto, "":
+		if !forced {
+			if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+				return ColorNone, nil
+			}
+			if f == nil || !term.IsTerminal(int(f.Fd())) {
+				return ColorNone, nil
+			}
+		}
+	default:
+		return ColorNone, fmt.Errorf("invalid color mode %q; must be %s, %s or %s", mode, ColorAuto, ColorAlways, ColorNever)
+	}
+
+	switch force {
+	case "1":
+		return Color16, nil
+	case "2":
+		return Color256, nil
+	case "3":
+		return ColorTrue, nil
+	}
+	colorterm := os.Getenv("COLORTERM")
+	termName := os.Getenv("TERM")
+	switch {
+	case colorterm == "truecolor" || colorterm == "24bit" || strings.HasSuffix(termName, "-direct"):
+		return ColorTrue, nil
+	case strings.Contains(termName, "256color"):
+		return Color256, nil
+	case strings.Contains(termName, "16color") || strings.HasPrefix(termName, "xterm") || termName == "":
+		// xterm compatibles, and Windows terminals that set no TERM, support bright colors
+		return Color16, nil
+	}
+	return Color8, nil
+}
+
+// ttyFormatter returns the Chroma terminal formatter for a color depth.
+func ttyFormatter(depth ColorDepth) chroma.Formatter {
+	switch {
+	case depth >= ColorTrue:
+		return formatters.TTY16m
+	case depth >= Color256:
+		return formatters.TTY256
+	case depth >= Color16:
+		return formatters.TTY16
+	}
+	return formatters.TTY8
+}
+
+// Highlight returns src colored for a terminal, with the identifier at
+// src[refStart:refEnd] marked.  A refStart of -1 marks nothing.
+func Highlight(src, style string, depth ColorDepth, refStart, refEnd int) string {
+	if depth == ColorNone {
+		return src
+	}
+	if refStart < 0 || refEnd > len(src) || refStart >= refEnd {
+		refStart, refEnd = len(src), len(src)
+	}
+	before, ref, after := src[:refStart], src[refStart:refEnd], src[refEnd:]
+
+	var b strings.Builder
+	if style == "" || style == "none" {
+		b.WriteString(before)
+		if ref != "" {
+			b.WriteString(highlightRef + ref + "\x1b[0m")
+		}
+		b.WriteString(after)
+		return b.String()
+	}
+
+	lexer := lexers.Get("go")
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	chromaStyle := styles.Get(style)
+	if chromaStyle == nil {
+		chromaStyle = styles.Fallback
+	}
+	tokens, err := chroma.Tokenise(lexer, nil, src)
+	if err != nil {
+		return src
+	}
+
+	// split the tokens around the referenced identifier so it can be marked
+	// independently of the syntax highlighting.
+	var pre, mid, post []chroma.Token
+	offset := 0
+	for _, tok := range tokens {
+		start, end := offset, offset+len(tok.Value)
+		offset = end
+		for _, part := range []struct {
+			to    *[]chroma.Token
+			start int
+			end   int
+		}{
+			{&pre, start, min(end, refStart)},
+			{&mid, max(start, refStart), min(end, refEnd)},
+			{&post, max(start, refEnd), end},
+		} {
+			if part.start < part.end {
+				*part.to = append(*part.to, chroma.Token{Type: tok.Type, Value: src[part.start:part.end]})
+			}
+		}
+	}
+
+	formatter := ttyFormatter(depth)
+	if err := formatter.Format(&b, chromaStyle, chroma.Literator(pre...)); err != nil {
+		return src
+	}
+	if len(mid) > 0 {
+		b.WriteString(highlightRef)
+		for _, tok := range mid {
+			b.WriteString(tok.Value)
+		}
+		b.WriteString("\x1b[0m")
+	}
+	if err := formatter.Format(&b, chromaStyle, chroma.Literator(post...)); err != nil {
+		return src
+	}
+	return b.String()
+}
+
+// prettyRef returns the byte range of the referenced identifier within
+// PrettySource, or -1, -1 if it cannot be located.
+//
+// PrettySource is reformatted, so the identifier is located by counting the
+// occurrences of the same identifier preceding it in OrgSource.
+func (me matchEntry) prettyRef() (int, int) {
+	if me.RefOffset < 0 || me.RefOffset >= len(me.OrgSource) {
+		return -1, -1
+	}
+	name := identAt(me.OrgSource, me.RefOffset)
+	if name == "" {
+		return -1, -1
+	}
+	n := len(identOffsets(me.OrgSource[:me.RefOffset], name))
+	offsets := identOffsets(me.PrettySource, name)
+	if n >= len(offsets) {
+		return -1, -1
+	}
+	return offsets[n], offsets[n] + len(name)
+}
+
+// identAt returns the identifier starting at offset i of s.
+func identAt(s string, i int) string {
+	end := i
+	for end < len(s) {
+		r, size := utf8.DecodeRuneInString(s[end:])
+		if !isIdentRune(r) {
+			break
+		}
+		end += size
+	}
+	return s[i:end]
+}
+
+// identOffsets returns the offsets of every occurrence of name in s that is not
+// part of a longer identifier.
+func identOffsets(s, name string) []int {
+	var offsets []int
+	for i := 0; i+len(name) <= len(s); {
+		j := strings.Index(s[i:], name)
+		if j < 0 {
+			break
+		}
+		start, end := i+j, i+j+len(name)
+		prev, _ := utf8.DecodeLastRuneInString(s[:start])
+		next, _ := utf8.DecodeRuneInString(s[end:])
+		if (start == 0 || !isIdentRune(prev)) && (end == len(s) || !isIdentRune(next)) {
+			offsets = append(offsets, start)
+		}
+		i = start + 1
+	}
+	return offsets
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,236 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// funcLitRe matches the elements of an enclosing chain naming a function literal,
+// which are numbered by their position within the enclosing function.
+var funcLitRe = regexp.MustCompile(`^func literal #\d+$`)
+
+// fingerprint returns an identifier for the reference that is independent of its
+// position, derived from the calling package, the enclosing function, the
+// referenced symbol and the source with whitespace normalized.  References keep
+// their fingerprint when code around them is added or removed, including other
+// function literals, or when the enclosing function is moved to another file.
+func (r Record) fingerprint() string {
+	chain := make([]string, len(r.Enclosing.Chain))
+	for i, elem := range r.Enclosing.Chain {
+		chain[i] = funcLitRe.ReplaceAllString(elem, "func literal")
+	}
+	h := sha256.New()
+	for _, s := range []string{
+		r.Caller.Package,
+		strings.Join(chain, "\x00"),
+		r.Enclosing.Function,
+		r.Target.Package + "." + r.Target.Symbol,
+		strings.Join(strings.Fields(r.Source.Text), " "),
+	} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// ReadRecords reads Records written by Json or JsonLines.  Fingerprints are
+// recomputed, so records written by earlier versions remain comparable.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	br := bufio.NewReader(r)
+	var records []Record
+	dec := json.NewDecoder(br)
+	first, err := firstNonSpace(br)
+	switch {
+	case err == io.EOF:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case first == '[':
+		if err := dec.Decode(&records); err != nil {
+			return nil, err
+		}
+	default:
+		for {
+			var rec Record
+			if err := dec.Decode(&rec); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			records = append(records, rec)
+		}
+	}
+	for i, rec := range records {
+		if rec.Schema > SchemaVersion {
+			return nil, fmt.Errorf("unsupported record schema version %d", rec.Schema)
+		}
+		records[i].Fingerprint = rec.fingerprint()
+	}
+	return records, nil
+}
+
+func firstNonSpace(br *bufio.Reader) (byte, error) {
+	for n := 1; ; n++ {
+		b, err := br.Peek(n)
+		if err != nil {
+			return 0, err
+		}
+		if c := b[n-1]; !strings.ContainsRune(" \t\r\n", rune(c)) {
+			return c, nil
+		}
+	}
+}
+
+// LoadRecords reads Records from a file written by Json or JsonLines.
+func LoadRecords(filename string) ([]Record, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := ReadRecords(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", filename, err)
+	}
+	return records, nil
+}
+
+// Diff holds the differences between two sets of references.
+type Diff struct {
+	Added     []Record `json:"added"`
+	Removed   []Record `json:"removed"`
+	Moved     []Move   `json:"moved"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// Move is a reference whose position changed without its fingerprint changing.
+type Move struct {
+	From Record `json:"from"`
+	To   Record `json:"to"`
+}
+
+// DiffRecords compares an old and new set of references by fingerprint.  Where
+// several references share a fingerprint, those at the same position are paired
+// first and the remainder are paired in position order.
+func DiffRecords(old, new []Record) Diff {
+	d := Diff{Added: []Record{}, Removed: []Record{}, Moved: []Move{}}
+	oldByFP := groupByFingerprint(old)
+	newByFP := groupByFingerprint(new)
+
+	for fp, newRecs := range newByFP {
+		oldRecs := slices.Clone(oldByFP[fp])
+		// references that have not moved are paired first, then the remainder
+		// are treated as moved in position order.
+		var movedTo []Record
+		for _, rec := range newRecs {
+			i := slices.IndexFunc(oldRecs, func(old Record) bool {
+				return old.File == rec.File && old.Line == rec.Line
+			})
+			if i >= 0 {
+				d.Unchanged++
+				oldRecs = slices.Delete(oldRecs, i, i+1)
+			} else {
+				movedTo = append(movedTo, rec)
+			}
+		}
+		n := min(len(oldRecs), len(movedTo))
+		for i := range n {
+			d.Moved = append(d.Moved, Move{From: oldRecs[i], To: movedTo[i]})
+		}
+		d.Added = append(d.Added, movedTo[n:]...)
+		d.Removed = append(d.Removed, oldRecs[n:]...)
+	}
+	for fp, oldRecs := range oldByFP {
+		if _, ok := newByFP[fp]; !ok {
+			d.Removed = append(d.Removed, oldRecs...)
+		}
+	}
+
+	slices.SortFunc(d.Added, compareRecords)
+	slices.SortFunc(d.Removed, compareRecords)
+	slices.SortFunc(d.Moved, func(a, b Move) int { return compareRecords(a.To, b.To) })
+	return d
+}
+
+func groupByFingerprint(records []Record) map[string][]Record {
+	groups := make(map[string][]Record)
+	for _, rec := range records {
+		fp := cmp.Or(rec.Fingerprint, rec.fingerprint())
+		groups[fp] = append(groups[fp], rec)
+	}
+	for _, group := range groups {
+		slices.SortFunc(group, compareRecords)
+	}
+	return groups
+}
+
+func compareRecords(a, b Record) int {
+	return cmp.Or(
+		cmp.Compare(a.File, b.File),
+		cmp.Compare(a.Line, b.Line),
+		cmp.Compare(a.Column, b.Column),
+	)
+}
+
+// ExcludeBaseline removes matches that are present in a baseline, such as one
+// previously written by Json, leaving only newly introduced references.
+func (m *Matcher) ExcludeBaseline(baseline []Record) {
+	m.sort()
+	remaining := make(map[string]int)
+	for _, rec := range baseline {
+		remaining[cmp.Or(rec.Fingerprint, rec.fingerprint())]++
+	}
+	m.refs = slices.DeleteFunc(m.refs, func(me matchEntry) bool {
+		fp := me.record().Fingerprint
+		if remaining[fp] > 0 {
+			remaining[fp]--
+			return true
+		}
+		return false
+	})
+}
+
+// WriteText writes the differences with one line per reference, prefixed by +
+// for added, - for removed and ~ for moved references, followed by a summary.
+func (d Diff) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, rec := range d.Added {
+		fmt.Fprintf(&b, "+ %s\n", formatDiffRecord(rec))
+	}
+	for _, rec := range d.Removed {
+		fmt.Fprintf(&b, "- %s\n", formatDiffRecord(rec))
+	}
+	for _, mv := range d.Moved {
+		fmt.Fprintf(&b, "~ %s:%d -> %s\n", mv.From.File, mv.From.Line, formatDiffRecord(mv.To))
+	}
+	fmt.Fprintf(&b, "%d added, %d removed, %d moved, %d unchanged\n", len(d.Added), len(d.Removed), len(d.Moved), d.Unchanged)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatDiffRecord(rec Record) string {
+	enc := rec.Enclosing.Function
+	if len(rec.Enclosing.Chain) > 0 {
+		enc = rec.Enclosing.Chain[0]
+	}
+	return fmt.Sprintf("%s:%d:%d %s: %s", rec.File, rec.Line, rec.Column, enc, strings.Join(strings.Fields(rec.Source.Text), " "))
+}
+
+// WriteJson writes the differences as a JSON object.
+func (d Diff) WriteJson(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
@@ -0,0 +1,160 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// rec returns a record of a call of Logger.Info with the source text at file:line.
+func rec(file string, line int, text string, chain ...string) Record {
+	r := Record{
+		Schema:    SchemaVersion,
+		File:      file,
+		Line:      line,
+		Target:    TargetRecord{Package: "go.uber.org/zap", Symbol: "Logger.Info"},
+		Caller:    CallerRecord{Package: "example.com/app"},
+		Enclosing: EnclosingRecord{Function: "Start", Chain: append([]string{"(*Server).Start"}, chain...)},
+		Source:    SourceRecord{Text: text},
+	}
+	r.Fingerprint = r.fingerprint()
+	return r
+}
+
+func TestFingerprint(t *testing.T) {
+	base := rec("server.go", 10, `log.Info("started")`, "func literal #1")
+	tests := []struct {
+		name string
+		r    Record
+		same bool
+	}{
+		{"moved", rec("other.go", 99, `log.Info("started")`, "func literal #1"), true},
+		{"whitespace", rec("server.go", 10, "\tlog.Info(\"started\")\n", "func literal #1"), true},
+		{"renumbered closure", rec("server.go", 10, `log.Info("started")`, "func literal #3"), true},
+		{"outside closure", rec("server.go", 10, `log.Info("started")`), false},
+		{"goroutine", rec("server.go", 10, `log.Info("started")`, "goroutine", "func literal #1"), false},
+		{"source", rec("server.go", 10, `log.Info("stopped")`, "func literal #1"), false},
+	}
+	for _, tc := range tests {
+		if same := tc.r.Fingerprint == base.Fingerprint; same != tc.same {
+			t.Errorf("%s: same fingerprint = %t, want %t", tc.name, same, tc.same)
+		}
+	}
+}
+
+func TestDiffRecords(t *testing.T) {
+	a := `log.Info("a")`
+	b := `log.Info("b")`
+	tests := []struct {
+		name     string
+		old, new []Record
+		want     string // added, removed, moved and unchanged positions
+	}{
+		{
+			name: "empty",
+			want: "added=[] removed=[] moved=[] unchanged=0",
+		},
+		{
+			name: "unchanged",
+			old:  []Record{rec("x.go", 1, a), rec("x.go", 5, b)},
+			new:  []Record{rec("x.go", 1, a), rec("x.go", 5, b)},
+			want: "added=[] removed=[] moved=[] unchanged=2",
+		},
+		{
+			name: "added and removed",
+			old:  []Record{rec("x.go", 1, a)},
+			new:  []Record{rec("x.go", 5, b)},
+			want: "added=[x.go:5] removed=[x.go:1] moved=[] unchanged=0",
+		},
+		{
+			name: "moved",
+			old:  []Record{rec("x.go", 1, a)},
+			new:  []Record{rec("y.go", 7, a)},
+			want: "added=[] removed=[] moved=[x.go:1->y.go:7] unchanged=0",
+		},
+		{
+			name: "duplicates pair unmoved first",
+			old:  []Record{rec("x.go", 1, a), rec("x.go", 3, a)},
+			new:  []Record{rec("x.go", 2, a), rec("x.go", 3, a), rec("x.go", 9, a)},
+			want: "added=[x.go:9] removed=[] moved=[x.go:1->x.go:2] unchanged=1",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := DiffRecords(tc.old, tc.new)
+			pos := func(r Record) string { return fmt.Sprintf("%s:%d", r.File, r.Line) }
+			var added, removed, moved []string
+			for _, r := range d.Added {
+				added = append(added, pos(r))
+			}
+			for _, r := range d.Removed {
+				removed = append(removed, pos(r))
+			}
+			for _, mv := range d.Moved {
+				moved = append(moved, pos(mv.From)+"->"+pos(mv.To))
+			}
+			got := fmt.Sprintf("added=[%s] removed=[%s] moved=[%s] unchanged=%d",
+				strings.Join(added, " "), strings.Join(removed, " "), strings.Join(moved, " "), d.Unchanged)
+			if got != tc.want {
+				t.Errorf("DiffRecords = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadRecords(t *testing.T) {
+	records := []Record{rec("x.go", 1, `log.Info("a")`), rec("x.go", 2, `log.Info("b")`, "func literal #2")}
+	array, err := json.Marshal(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines strings.Builder
+	for _, r := range records {
+		// fingerprints written by earlier versions are replaced
+		r.Fingerprint = "stale"
+		line, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines.Write(append(line, '\n'))
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{name: "json", input: "\n  " + string(array), want: 2},
+		{name: "jsonl", input: lines.String(), want: 2},
+		{name: "empty", input: " \n", want: 0},
+		{name: "newer schema", input: `{"schema": 99}`, wantErr: true},
+		{name: "invalid", input: `[{"schema": 1}`, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ReadRecords(strings.NewReader(tc.input))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("ReadRecords succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tc.want {
+				t.Fatalf("ReadRecords returned %d records, want %d", len(got), tc.want)
+			}
+			for i, r := range got {
+				if r.Fingerprint != records[i].Fingerprint {
+					t.Errorf("record %d has fingerprint %q, want %q", i, r.Fingerprint, records[i].Fingerprint)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/gwatts/plsdo/pkg/ast"
+	"github.com/ryanuber/go-glob"
+)
+
+// Filter restricts the references collected by a Matcher.
+//
+// Path globs are matched against the filename relative to the module root.
+// A glob without a slash, such as `*_gen.go` or `vendor`, matches the base name
+// or any directory name; `**/mocks/**` matches a mocks directory at any depth.
+type Filter struct {
+	Include     []string // if set, only include references in files matching one of these globs
+	Exclude     []string // exclude references in files matching any of these globs
+	In          []string // only include references within enclosing functions matching these patterns, eg. `Server.*`
+	ExcludeDecl bool     // exclude references that are the declaration itself
+	Modules     []string // if set, only include references within modules whose path matches one of these globs
+}
+
+// matchPath reports whether the relative filename should be included.
+func (f Filter) matchPath(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	if len(f.Include) > 0 && !matchAnyPath(f.Include, rel) {
+		return false
+	}
+	return !matchAnyPath(f.Exclude, rel)
+}
+
+// matchModule reports whether a reference within the module with the given path
+// should be included.
+func (f Filter) matchModule(path string) bool {
+	if len(f.Modules) == 0 {
+		return true
+	}
+	for _, pattern := range f.Modules {
+		if glob.Glob(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchEnclosing reports whether a reference within the named function should be included.
+func (f Filter) matchEnclosing(recvType, funcName string) bool {
+	if len(f.In) == 0 {
+		return true
+	}
+	return ast.MatchFuncName(recvType, funcName, f.In...)
+}
+
+func matchAnyPath(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if matchPath(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPath(pattern, rel string) bool {
+	pattern = filepath.ToSlash(pattern)
+	if !strings.Contains(pattern, "/") {
+		for _, elem := range strings.Split(rel, "/") {
+			if glob.Glob(pattern, elem) {
+				return true
+			}
+		}
+		return false
+	}
+	if glob.Glob(pattern, rel) {
+		return true
+	}
+	// a leading **/ also matches at the root
+	if trimmed, ok := strings.CutPrefix(pattern, "**/"); ok {
+		return glob.Glob(trimmed, rel)
+	}
+	return false
+}
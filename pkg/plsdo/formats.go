@@ -0,0 +1,178 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gwatts/plsdo/pkg/gofiles"
+)
+
+// FormatOptions holds settings used by output formats.
+type FormatOptions struct {
+	Style string     // Chroma style used for syntax highlighting, or "none"
+	Color ColorDepth // colors supported by a terminal; ColorNone disables terminal coloring
+}
+
+// Formatter writes a set of matches in a particular output format.
+//
+// Formatters registered with RegisterFormatter may be selected by name using
+// Matcher.Write; implementations outside this package can use Matcher.Records
+// to retrieve the matches.
+type Formatter interface {
+	Format(w io.Writer, m *Matcher, opts FormatOptions) error
+}
+
+// FormatterFunc adapts an ordinary function to a Formatter.
+type FormatterFunc func(w io.Writer, m *Matcher, opts FormatOptions) error
+
+// Format calls f(w, m, opts).
+func (f FormatterFunc) Format(w io.Writer, m *Matcher, opts FormatOptions) error {
+	return f(w, m, opts)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Formatter{
+		"print": FormatterFunc(func(w io.Writer, m *Matcher, opts FormatOptions) error {
+			m.PrettyPrint(w, opts.Style, opts.Color)
+			return nil
+		}),
+		"json": FormatterFunc(func(w io.Writer, m *Matcher, opts FormatOptions) error {
+			return m.Json(w)
+		}),
+		"jsonl": FormatterFunc(func(w io.Writer, m *Matcher, opts FormatOptions) error {
+			return m.JsonLines(w)
+		}),
+		"csv": FormatterFunc(func(w io.Writer, m *Matcher, opts FormatOptions) error {
+			return m.Csv(w)
+		}),
+		"sarif": FormatterFunc(func(w io.Writer, m *Matcher, opts FormatOptions) error {
+			return m.Sarif(w)
+		}),
+		"markdown": FormatterFunc(func(w io.Writer, m *Matcher, opts FormatOptions) error {
+			return m.Markdown(w)
+		}),
+		"html": FormatterFunc(func(w io.Writer, m *Matcher, opts FormatOptions) error {
+			return m.Html(w, opts.Style)
+		}),
+		"grep": FormatterFunc(func(w io.Writer, m *Matcher, opts FormatOptions) error {
+			return m.Grep(w, "%s:%d:%d: %s\n")
+		}),
+		"vimgrep": FormatterFunc(func(w io.Writer, m *Matcher, opts FormatOptions) error {
+			return m.Grep(w, "%s:%d:%d:%s\n")
+		}),
+	}
+)
+
+// RegisterFormatter makes a Formatter available by name, replacing any existing
+// formatter with the same name.
+func RegisterFormatter(name string, f Formatter) {
+	if f == nil {
+		panic("plsdo: RegisterFormatter formatter is nil")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = f
+}
+
+// LookupFormatter returns the Formatter registered with the given name.
+func LookupFormatter(name string) (Formatter, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	f, ok := registry[name]
+	return f, ok
+}
+
+// Formats returns the names of the registered output formats.
+func Formats() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return slices.Sorted(maps.Keys(registry))
+}
+
+// Write outputs the current matches using the named format.
+func (m *Matcher) Write(w io.Writer, format string, opts FormatOptions) error {
+	f, ok := LookupFormatter(format)
+	if !ok {
+		return fmt.Errorf("unknown format %q; available formats: %s", format, strings.Join(Formats(), ", "))
+	}
+	return f.Format(w, m, opts)
+}
+
+// Grep outputs one line per match using a format taking the filename relative to
+// the directory queries are run in, line, column and single-line source, suitable
+// for editor quickfix lists.
+func (m *Matcher) Grep(w io.Writer, format string) error {
+	m.sort()
+	for _, ref := range m.refs {
+		source := strings.Join(strings.Fields(ref.OrgSource), " ")
+		if _, err := fmt.Fprintf(w, format, m.dirRelative(ref.Filename), ref.Line, ref.Column, source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Markdown outputs matches as a summary table followed by fenced code blocks
+// grouped by file, suitable for pull request comments.
+func (m *Matcher) Markdown(w io.Writer) error {
+	m.sort()
+	var b strings.Builder
+	fmt.Fprintf(&b, "## References (%d)\n\n", len(m.refs))
+	if len(m.refs) == 0 {
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	b.WriteString("| File | Line | Function | Target |\n")
+	b.WriteString("| ---- | ---: | -------- | ------ |\n")
+	for _, ref := range m.refs {
+		fmt.Fprintf(&b, "| %s | %d | %s | %s |\n",
+			mdCell(ref.relFilename()), ref.Line, mdCell(ref.fmtEnc()), mdCell(ref.TargetPkg+"."+ref.TargetSymbol))
+	}
+
+	lastFilename := ""
+	for _, ref := range m.refs {
+		if ref.Filename != lastFilename {
+			fmt.Fprintf(&b, "\n### %s\n", ref.relFilename())
+			lastFilename = ref.Filename
+		}
+		fmt.Fprintf(&b, "\n**%s** line %d\n\n", mdEscape(ref.fmtEnc()), ref.Line)
+		fence := "```"
+		for strings.Contains(ref.PrettySource, fence) {
+			fence += "`"
+		}
+		fmt.Fprintf(&b, "%sgo\n%s\n%s\n", fence, ref.PrettySource, fence)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mdCell escapes text for use within a markdown table cell.
+func mdCell(s string) string {
+	return "`" + strings.ReplaceAll(strings.Join(strings.Fields(s), " "), "|", `\|`) + "`"
+}
+
+// mdEscape escapes markdown emphasis characters.
+func mdEscape(s string) string {
+	return strings.NewReplacer("*", `\*`, "_", `\_`, "`", "\\`").Replace(s)
+}
+
+// dirRelative returns filename relative to the directory queries are run in, as
+// returned by Dir, if within it.
+func (m *Matcher) dirRelative(filename string) string {
+	if gofiles.Within(m.dir, filename) {
+		if rel, err := filepath.Rel(m.dir, filename); err == nil {
+			return rel
+		}
+	}
+	return filename
+}
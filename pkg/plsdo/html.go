@@ -0,0 +1,142 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"cmp"
+	"html/template"
+	"io"
+	"strings"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>plsdo references</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; }
+h2 { font-family: monospace; border-bottom: 1px solid #ccc; padding-bottom: .2em; margin-top: 2em; }
+h3 { font-family: monospace; font-size: 1em; margin-bottom: .3em; }
+.ref { margin: 0 0 1em 1em; }
+.meta { color: #666; font-size: .85em; }
+pre { padding: .5em; overflow-x: auto; margin: .2em 0; }
+nav li { font-family: monospace; }
+{{.CSS}}
+</style>
+</head>
+<body>
+<h1>References ({{.Count}})</h1>
+<nav><ul>
+{{- range .Files}}
+<li><a href="#{{.ID}}">{{.Name}}</a> ({{.Count}})</li>
+{{- end}}
+</ul></nav>
+{{- range .Files}}
+<h2 id="{{.ID}}">{{.Name}}</h2>
+{{- range .Groups}}
+<h3>{{.Enclosing}}</h3>
+{{- range .Refs}}
+<div class="ref">
+<div class="meta">line {{.Line}}:{{.Column}} &rarr; {{.Target}}{{if .Instance}} [{{.Instance}}]{{end}}{{if .Test}} (test){{end}}</div>
+{{.Code}}
+</div>
+{{- end}}
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+type htmlFile struct {
+	ID     string
+	Name   string
+	Count  int
+	Groups []*htmlGroup
+}
+
+type htmlGroup struct {
+	Enclosing string
+	Refs      []htmlRef
+}
+
+type htmlRef struct {
+	Line     int
+	Column   int
+	Target   string
+	Instance string
+	Test     bool
+	Code     template.HTML
+}
+
+// Html outputs matches as a self-contained HTML report, grouped by file and
+// enclosing function.  style is a Chroma style, or "none" for no coloring.
+func (m *Matcher) Html(w io.Writer, style string) error {
+	m.sort()
+
+	var css strings.Builder
+	highlight := style != "" && style != "none"
+	chromaStyle := styles.Get(style)
+	if chromaStyle == nil {
+		chromaStyle = styles.Fallback
+	}
+	if highlight {
+		formatter := chromahtml.New(chromahtml.WithClasses(true), chromahtml.WithLineNumbers(true))
+		if err := formatter.WriteCSS(&css, chromaStyle); err != nil {
+			return err
+		}
+	}
+	lexer := lexers.Get("go")
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+
+	var files []*htmlFile
+	for _, ref := range m.refs {
+		name := ref.relFilename()
+		if len(files) == 0 || files[len(files)-1].Name != name {
+			files = append(files, &htmlFile{ID: "f" + strings.NewReplacer("/", "-", ".", "-").Replace(name), Name: name})
+		}
+		file := files[len(files)-1]
+		file.Count++
+
+		enc := ref.fmtEnc()
+		if len(file.Groups) == 0 || file.Groups[len(file.Groups)-1].Enclosing != enc {
+			file.Groups = append(file.Groups, &htmlGroup{Enclosing: enc})
+		}
+		group := file.Groups[len(file.Groups)-1]
+
+		code := template.HTML("<pre>" + template.HTMLEscapeString(ref.PrettySource) + "</pre>")
+		if highlight {
+			startLine := cmp.Or(ref.SrcStartLine, ref.Line)
+			formatter := chromahtml.New(chromahtml.WithClasses(true), chromahtml.WithLineNumbers(true), chromahtml.BaseLineNumber(startLine))
+			var buf strings.Builder
+			iterator, err := lexer.Tokenise(nil, ref.PrettySource)
+			if err == nil {
+				err = formatter.Format(&buf, chromaStyle, iterator)
+			}
+			if err == nil {
+				code = template.HTML(buf.String())
+			}
+		}
+		group.Refs = append(group.Refs, htmlRef{
+			Line:     ref.Line,
+			Column:   ref.Column,
+			Target:   ref.TargetPkg + "." + ref.TargetSymbol,
+			Instance: ref.Instance,
+			Test:     ref.InTest,
+			Code:     code,
+		})
+	}
+
+	return htmlReport.Execute(w, map[string]any{
+		"CSS":   template.CSS(css.String()),
+		"Count": len(m.refs),
+		"Files": files,
+	})
+}
@@ -6,58 +6,228 @@ package plsdo
 import (
 	"cmp"
 	"encoding/csv"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/alecthomas/chroma/v2/formatters"
-	"github.com/alecthomas/chroma/v2/lexers"
-	"github.com/alecthomas/chroma/v2/styles"
 	"github.com/gwatts/plsdo/pkg/ast"
+	"github.com/gwatts/plsdo/pkg/cache"
+	"github.com/gwatts/plsdo/pkg/git"
+	"github.com/gwatts/plsdo/pkg/gofiles"
 	"github.com/gwatts/plsdo/pkg/gopls"
+	"golang.org/x/sync/errgroup"
 )
 
 type matchEntry struct {
 	Filename     string
 	Line         int
+	Column       int
+	EndLine      int
+	EndColumn    int
+	SrcStartLine int    // first line of the extracted source
+	SrcEndLine   int    // last line of the extracted source
+	TargetPkg    string // import path of the referenced function's package
+	TargetSymbol string // referenced function, eg. Logger.Info
+	RuleID       string // package and pattern that matched the referenced function, eg. go.uber.org/zap.Logger.*
+	CallerPkg    string // import path of the package containing the reference
+	CallerModule string // module path of the package containing the reference
+	FileRoot     string // directory Record.File is relative to: the go.work directory, or the root of the module
 	EncRecvType  string
 	EncRecvName  string
 	EncFuncName  string
+	EncChain     []string // full lexical chain of the enclosing context
+	Package      string   // name of the package containing the reference
+	InGo         bool     // reference is run by the goroutine of a go statement
+	InDefer      bool     // reference is run by the call of a defer statement
+	InTest       bool
+	Instance     string
+	Args         []ast.Arg
 	OrgSource    string
+	RefOffset    int // byte offset of the reference within OrgSource
 	PrettySource string
+	Before       []string       // context lines preceding the source
+	After        []string       // context lines following the source
+	Blame        *git.BlameLine // commit that last modified the reference, if requested
+	Label        string         // label of the query that found the reference
 }
 
 func (me matchEntry) fmtEnc() string {
+	var enc string
 	if me.EncRecvType != "" {
 		if me.EncRecvName != "" {
-			return fmt.Sprintf("(%s %s) %s(...)", me.EncRecvName, me.EncRecvType, me.EncFuncName)
+			enc = fmt.Sprintf("(%s %s) %s(...)", me.EncRecvName, me.EncRecvType, me.EncFuncName)
 		} else {
-			return fmt.Sprintf("(%s) %s(...)", me.EncRecvType, me.EncFuncName)
+			enc = fmt.Sprintf("(%s) %s(...)", me.EncRecvType, me.EncFuncName)
 		}
+	} else {
+		enc = fmt.Sprintf("%s(...)", me.EncFuncName)
 	}
-	return fmt.Sprintf("%s(...)", me.EncFuncName)
+	// closures, goroutines and deferred calls within the named function
+	for _, elem := range me.EncChain[min(1, len(me.EncChain)):] {
+		enc += ast.ChainSeparator + elem
+	}
+	return enc
 }
 
 // Matcher wraps ast and gopls to find matching functions and methods.
 type Matcher struct {
 	refs        []matchEntry
 	pls         *gopls.GoplsClient
+	ap          *ast.ASTProcessor // reused across queries, retaining parsed files
+	dir         string            // absolute path of the directory queries are run in
+	ws          Workspace
+	srcRoot     string // set by SourceRoot
+	build       ast.BuildConfig
 	DebugWriter io.Writer
+	// Visibility controls whether unexported functions and methods are matched.
+	// By default they are only matched in packages belonging to the current module.
+	Visibility ast.Visibility
+	// Instances restricts references to generic functions and methods to those
+	// instantiated with matching type arguments, eg. "Cache[string, *User]".
+	Instances []string
+	// Filter restricts references by path and enclosing function.
+	Filter Filter
+	// CallArgs enables analysis of the arguments of each matched call.
+	// It is implied if Where is set.
+	CallArgs bool
+	// Where restricts references to calls whose arguments match all conditions.
+	Where []Condition
+	// Statements extracts the enclosing statement of each reference, rather than the call.
+	Statements bool
+	// ContextBefore and ContextAfter set the number of surrounding source lines to include.
+	ContextBefore int
+	ContextAfter  int
+	// Changes, if not nil, restricts references to the added or modified lines
+	// returned by git.ChangedSince.
+	Changes git.Changes
+	// Blame looks up the commit that last modified each reference.
+	Blame bool
+	// Cache, if set, stores the definitions and references found, so they need
+	// not be found again until the source files they depend on change.
+	Cache *cache.Cache
+	// Concurrency is the maximum number of reference requests sent to gopls at
+	// once; defaults to 4.
+	Concurrency int
+}
+
+// Option configures a Matcher created by NewMatcher.
+type Option func(m *Matcher)
+
+// WithBuildConfig sets the build configuration used both to locate definitions
+// and by gopls to find references, allowing test files, other platforms and
+// build tags to be included.
+func WithBuildConfig(cfg ast.BuildConfig) Option {
+	return func(m *Matcher) {
+		m.build = cfg
+	}
+}
+
+// WithDir runs queries as if from dir, rather than the current directory.  The
+// workspace searched, the packages that patterns resolve to and the main module
+// used to determine visibility are all determined by dir, and output filenames
+// are relative to it.
+func WithDir(dir string) Option {
+	return func(m *Matcher) {
+		m.dir = dir
+	}
+}
+
+// BuildConfig returns the build configuration set by WithBuildConfig.
+func (m *Matcher) BuildConfig() ast.BuildConfig {
+	return m.build
 }
 
-// NewMatcher creates an initialized Matcher.
-func NewMatcher() (*Matcher, error) {
-	pls, err := gopls.NewGoplsClient(".")
+// NewMatcher creates an initialized Matcher.  The gopls process is started when
+// it is first required, so queries answered entirely from the cache do not start it.
+func NewMatcher(opts ...Option) (*Matcher, error) {
+	m := &Matcher{}
+	for _, opt := range opts {
+		opt(m)
+	}
+	dir, err := filepath.Abs(cmp.Or(m.dir, "."))
 	if err != nil {
 		return nil, err
 	}
-	return &Matcher{
-		pls: pls,
-	}, nil
+	m.dir = dir
+	ws, err := FindWorkspace(dir, m.build.Env())
+	if err != nil {
+		return nil, err
+	}
+	m.ws = ws
+	return m, nil
+}
+
+// Dir returns the absolute path of the directory queries are run in, as set by
+// WithDir.
+func (m *Matcher) Dir() string {
+	return m.dir
+}
+
+// Workspace returns the workspace searched for references.
+func (m *Matcher) Workspace() Workspace {
+	return m.ws
+}
+
+// SourceRoot returns the top-level directory of the git repository containing the
+// workspace, or the workspace root if it is not within a repository.  Filenames
+// in SARIF output and GitHub annotations are relative to it, as code scanning
+// expects.
+func (m *Matcher) SourceRoot() string {
+	if m.srcRoot == "" {
+		root, err := git.Root(m.ws.Root)
+		if err != nil {
+			m.debugPrintf("using the workspace root as the source root: %v\n", err)
+			root = m.ws.Root
+		}
+		m.srcRoot = root
+	}
+	return m.srcRoot
+}
+
+// client returns the gopls client, starting gopls if necessary.
+func (m *Matcher) client() (*gopls.GoplsClient, error) {
+	if m.pls != nil {
+		return m.pls, nil
+	}
+	env := make(map[string]string)
+	if m.build.GOOS != "" {
+		env["GOOS"] = m.build.GOOS
+	}
+	if m.build.GOARCH != "" {
+		env["GOARCH"] = m.build.GOARCH
+	}
+	opts := gopls.Options{
+		BuildFlags: m.build.BuildFlags(),
+		Env:        env,
+	}
+	if m.ws.Work != "" {
+		for _, mod := range m.ws.Modules {
+			opts.WorkspaceFolders = append(opts.WorkspaceFolders, mod.Dir)
+		}
+	}
+	pls, err := gopls.NewGoplsClient(m.ws.Root, opts)
+	if err != nil {
+		return nil, err
+	}
+	m.pls = pls
+	return pls, nil
+}
+
+// processor returns the ASTProcessor, configured for the current settings.
+func (m *Matcher) processor(interfaces bool) *ast.ASTProcessor {
+	if m.ap == nil {
+		m.ap = ast.NewASTProcessor()
+	}
+	m.ap.Visibility = m.Visibility
+	m.ap.Build = m.build
+	m.ap.Dir = m.dir
+	m.ap.Interfaces = interfaces
+	return m.ap
 }
 
 // Close closes the connection to the underlying gopls process.
@@ -68,17 +238,61 @@ func (m *Matcher) Close() {
 	m.pls = nil
 }
 
+// Reset discards the references found, so the Matcher can be reused to repeat a
+// query after files have changed.
+func (m *Matcher) Reset() {
+	m.refs = nil
+	if m.Cache != nil {
+		m.Cache.Reset()
+	}
+}
+
+// FilesChanged notifies gopls of files that were created, modified or deleted
+// since it was started, and discards any previously parsed copies.
+func (m *Matcher) FilesChanged(events []gopls.FileEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if m.ap != nil {
+		filenames := make([]string, len(events))
+		for i, ev := range events {
+			filenames[i] = ev.Filename
+		}
+		m.ap.Forget(filenames...)
+	}
+	if m.pls == nil {
+		return nil
+	}
+	return m.pls.DidChangeWatchedFiles(events)
+}
+
 // PrettyPrint prints all matches to the supplied output.
-// style is a Chroma style, or "none" for no coloring.
-func (m *Matcher) PrettyPrint(w io.Writer, style string) {
+// style is a Chroma style, or "none" for no syntax highlighting, and depth is the
+// number of colors supported by the output; ColorNone disables coloring entirely.
+func (m *Matcher) PrettyPrint(w io.Writer, style string, depth ColorDepth) {
 	m.sort()
 	lastFilename := ""
 	lastEnc := ""
+	multiModule := m.ws.multiModule()
 
 	for _, ref := range m.refs {
 		if ref.Filename != lastFilename {
 			fmt.Fprintln(w)
-			fmt.Fprintf(w, "+++ %s:%d\n", ref.Filename, ref.Line)
+			var notes []string
+			if ref.Package != "" {
+				notes = append(notes, "package "+ref.Package)
+			}
+			if multiModule && ref.CallerModule != "" {
+				notes = append(notes, ref.CallerModule)
+			}
+			if ref.InTest {
+				notes = append(notes, "test")
+			}
+			if len(notes) > 0 {
+				fmt.Fprintf(w, "+++ %s:%d (%s)\n", ref.Filename, ref.Line, strings.Join(notes, ", "))
+			} else {
+				fmt.Fprintf(w, "+++ %s:%d\n", ref.Filename, ref.Line)
+			}
 			lastFilename = ref.Filename
 			lastEnc = ""
 		}
@@ -91,57 +305,46 @@ func (m *Matcher) PrettyPrint(w io.Writer, style string) {
 		} else {
 			fmt.Fprintln(w, "...")
 		}
-
-		formattedLines := strings.Split(ref.PrettySource, "\n")
-		if style != "" && style != "none" {
-			// Set up the lexer, formatter, and style for syntax highlighting
-			lexer := lexers.Get("go")
-			if lexer == nil {
-				lexer = lexers.Fallback
-			}
-
-			style := styles.Get(style)
-			if style == nil {
-				style = styles.Fallback
-			}
-
-			formatter := formatters.TTY16m // For 24-bit color terminals
-
-			// Tokenize the input code
-			var buf strings.Builder
-			iterator, err := lexer.Tokenise(nil, ref.PrettySource)
-			if err == nil {
-				err = formatter.Format(&buf, style, iterator)
-			}
-			if err == nil {
-				formattedLines = strings.Split(buf.String(), "\n")
-			}
+		if ref.Instance != "" {
+			fmt.Fprintf(w, "       instance: %s\n", ref.Instance)
 		}
+		if ref.Label != "" {
+			fmt.Fprintf(w, "       query: %s\n", ref.Label)
+		}
+
+		refStart, refEnd := ref.prettyRef()
+		formattedLines := strings.Split(Highlight(ref.PrettySource, style, depth, refStart, refEnd), "\n")
 
 		// Print each line with the line number
+		startLine := cmp.Or(ref.SrcStartLine, ref.Line)
+		for i, line := range ref.Before {
+			fmt.Fprintf(w, "%5d- %s\n", startLine-len(ref.Before)+i, line)
+		}
 		for i, line := range formattedLines {
-			fmt.Fprintf(w, "%5d  %s\n", ref.Line+i, line)
+			fmt.Fprintf(w, "%5d  %s\n", startLine+i, line)
 		}
-	}
-}
-
-// Json outputs matches in json format.
-func (m *Matcher) Json(w io.Writer) error {
-	m.sort()
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	for _, me := range m.refs {
-		if err := enc.Encode(me); err != nil {
-			return err
+		for i, line := range ref.After {
+			fmt.Fprintf(w, "%5d- %s\n", ref.SrcEndLine+1+i, line)
 		}
 	}
-	return nil
 }
 
+// Csv outputs matches in csv format.
 func (m *Matcher) Csv(w io.Writer) error {
 	m.sort()
 	enc := csv.NewWriter(w)
-	header := []string{"filename", "line", "enclosing_method", "source"}
+	header := []string{"filename", "line", "enclosing_method", "source", "instance", "test", "package", "go", "defer"}
+	if m.Blame {
+		header = append(header, "blame_commit", "blame_author", "blame_date")
+	}
+	labelled := m.labelled()
+	if labelled {
+		header = append(header, "label")
+	}
+	multiModule := m.ws.multiModule()
+	if multiModule {
+		header = append(header, "module")
+	}
 	if err := enc.Write(header); err != nil {
 		return err
 	}
@@ -152,6 +355,24 @@ func (m *Matcher) Csv(w io.Writer) error {
 			strconv.Itoa(me.Line),
 			me.fmtEnc(),
 			me.PrettySource,
+			me.Instance,
+			strconv.FormatBool(me.InTest),
+			me.Package,
+			strconv.FormatBool(me.InGo),
+			strconv.FormatBool(me.InDefer),
+		}
+		if m.Blame {
+			if me.Blame != nil {
+				entry = append(entry, me.Blame.Commit, me.Blame.Author, me.Blame.Time.Format(time.RFC3339))
+			} else {
+				entry = append(entry, "", "", "")
+			}
+		}
+		if labelled {
+			entry = append(entry, me.Label)
+		}
+		if multiModule {
+			entry = append(entry, me.CallerModule)
 		}
 		if err := enc.Write(entry); err != nil {
 			return err
@@ -161,65 +382,331 @@ func (m *Matcher) Csv(w io.Writer) error {
 	return enc.Error()
 }
 
-// FindFuncReferences scans the module in the current working directory for all
-// references to the named functions or methods in a specific package, and adds any
-// matches to the current match set.  It can be called multiple times to add additional
-// matches across different packages.
+// FindFuncReferences finds all references to the named functions or methods in a
+// specific package, and adds them to the current match set.  The package and
+// patterns are resolved from the Matcher's directory, as set by WithDir, and the
+// references are searched for in the module containing it, or in every module
+// of its go.work workspace.  It can be called multiple times to add matches
+// across different packages.
 func (m *Matcher) FindFuncReferences(pkgName string, patterns ...string) error {
-	pwd, _ := filepath.Abs(".")
+	return m.FindQueries(Query{Package: pkgName, Patterns: patterns})
+}
 
-	ap := ast.NewASTProcessor()
-	defs, err := ap.FindFuncDefinitions(pkgName, patterns...)
-	if err != nil {
-		return err
+// FindQueries finds the references matching each query, as FindFuncReferences,
+// labelling each reference with the query's Label.  The definitions matched by
+// every query are found first, and the references to them are then requested
+// from gopls concurrently.
+func (m *Matcher) FindQueries(queries ...Query) error {
+	first := len(m.refs)
+
+	ap := m.processor(false)
+	var defs []ast.Match
+	var labels []string // query label of each definition
+	for _, q := range queries {
+		qdefs, err := m.findDefinitions(ap, q.Package, q.Patterns)
+		if err != nil {
+			if q.Label != "" {
+				return fmt.Errorf("query %s: %v", q.Label, err)
+			}
+			return err
+		}
+		defs = append(defs, qdefs...)
+		for range qdefs {
+			labels = append(labels, q.Label)
+		}
 	}
 	m.debug(func() {
 		for _, def := range defs {
 			m.debugPrintf("found %s -> %s at %s:%d:%d\n", def.Pkg, def.MethodName(), def.Filename, def.OffsetLine, def.OffsetCol)
 		}
 	})
+	refs, err := m.findReferences(defs)
+	if err != nil {
+		return err
+	}
 
-	// for each matching definition, find refs to it
-	for _, def := range defs {
-		matches, err := m.pls.FindReferences(def.Filename, def.OffsetLine, def.OffsetCol)
-		if err != nil {
-			return err
-		}
-		for _, match := range matches {
-			if !strings.HasPrefix(match.Filename, pwd) {
+	// for each matching definition, add the refs to it
+	for i, def := range defs {
+		for _, match := range refs[i] {
+			if !gofiles.Within(m.ws.Root, match.Filename) {
+				continue
+			}
+			// paths are matched relative to the module containing them
+			root := m.ws.Root
+			mod, _ := m.ws.moduleOf(match.Filename)
+			if mod.Dir != "" {
+				root = mod.Dir
+			}
+			if rel, err := filepath.Rel(root, match.Filename); err != nil || !m.Filter.matchPath(rel) {
+				continue
+			}
+			if !m.Filter.matchModule(mod.Path) {
+				continue
+			}
+			if m.Filter.ExcludeDecl && match.Filename == def.Filename && match.StartLine == def.OffsetLine && match.StartCharacter == def.OffsetCol {
+				continue
+			}
+			if m.Changes != nil && !m.Changes.Contains(match.Filename, match.StartLine) {
 				continue
 			}
+			inTest := strings.HasSuffix(match.Filename, "_test.go")
 
-			functionName, receiverType, receiverName, err := ap.GetEnclosingFunctionName(match.Filename, match.StartLine, match.StartCharacter)
+			var instance string
+			if len(m.Instances) > 0 {
+				inst, ok, err := ap.Instantiation(match.Filename, match.StartLine, match.StartCharacter)
+				if err != nil {
+					return err
+				}
+				if !ok || !matchInstance(inst, m.Instances) {
+					continue
+				}
+				instance = inst.String()
+			}
+
+			enc, err := ap.GetEnclosing(match.Filename, match.StartLine, match.StartCharacter)
 			if err != nil {
 				return err
 			}
+			if !m.Filter.matchEnclosing(enc.RecvType, enc.FuncName) {
+				continue
+			}
+
+			var args []ast.Arg
+			if m.CallArgs || len(m.Where) > 0 {
+				args, err = ap.CallArgs(match.Filename, match.StartLine, match.StartCharacter)
+				switch {
+				case errors.Is(err, ast.ErrNoCall):
+					// conditions on the arguments of a call can't hold for a reference
+					// that isn't called
+					if len(m.Where) > 0 {
+						continue
+					}
+				case err != nil:
+					return err
+				case !matchConditions(m.Where, args):
+					continue
+				}
+			}
 
-			src, err := ap.ExtractFullCall(match.Filename, match.StartLine, match.StartCharacter)
+			var snippet ast.Snippet
+			if !m.Statements {
+				snippet, err = ap.ExtractCall(match.Filename, match.StartLine, match.StartCharacter)
+			}
+			if m.Statements || errors.Is(err, ast.ErrNoCall) {
+				snippet, err = ap.ExtractStatement(match.Filename, match.StartLine, match.StartCharacter)
+			}
+			if err != nil {
+				return err
+			}
+			before, err := ap.Lines(match.Filename, snippet.StartLine-m.ContextBefore, snippet.StartLine-1)
+			if err != nil {
+				return err
+			}
+			after, err := ap.Lines(match.Filename, snippet.EndLine+1, snippet.EndLine+m.ContextAfter)
 			if err != nil {
 				return err
 			}
 			me := matchEntry{
 				Filename:     match.Filename,
 				Line:         match.StartLine,
-				EncRecvType:  receiverType,
-				EncRecvName:  receiverName,
-				EncFuncName:  functionName,
-				OrgSource:    src,
-				PrettySource: ast.Format(src),
+				Column:       match.StartCharacter,
+				EndLine:      match.EndLine,
+				EndColumn:    match.EndCharacter,
+				SrcStartLine: snippet.StartLine,
+				SrcEndLine:   snippet.EndLine,
+				TargetPkg:    def.Pkg,
+				TargetSymbol: def.Symbol(),
+				RuleID:       def.Pkg + "." + def.Pattern,
+				EncRecvType:  enc.RecvType,
+				EncRecvName:  enc.RecvName,
+				EncFuncName:  enc.FuncName,
+				EncChain:     enc.Chain,
+				Package:      enc.Package,
+				InGo:         enc.InGo,
+				InDefer:      enc.InDefer,
+				InTest:       inTest,
+				Instance:     instance,
+				Args:         args,
+				OrgSource:    snippet.Source,
+				RefOffset:    snippet.RefOffset,
+				PrettySource: ast.Format(snippet.Source),
+				Before:       before,
+				After:        after,
+				Label:        labels[i],
 			}
 			m.refs = append(m.refs, me)
 		}
 	}
+	if err := m.resolveCallers(ap, m.refs[first:]); err != nil {
+		return err
+	}
+	if m.Blame {
+		return resolveBlame(m.refs[first:])
+	}
+	return nil
+}
+
+// labelled reports whether any match was found by a labelled query.
+func (m *Matcher) labelled() bool {
+	return slices.ContainsFunc(m.refs, func(me matchEntry) bool { return me.Label != "" })
+}
+
+// resolveCallers sets the package and module of the supplied references, and the
+// directory their filenames are reported relative to.
+func (m *Matcher) resolveCallers(ap *ast.ASTProcessor, refs []matchEntry) error {
+	var files []string
+	for _, ref := range refs {
+		files = append(files, ref.Filename)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	pkgs, err := ap.FilePackages(files...)
+	if err != nil {
+		return err
+	}
+	for i := range refs {
+		pkg, ok := pkgs[refs[i].Filename]
+		if !ok {
+			continue
+		}
+		refs[i].CallerPkg = pkg.PkgPath
+		if pkg.Module != nil {
+			refs[i].CallerModule = pkg.Module.Path
+			refs[i].FileRoot = m.ws.fileRoot(pkg.Module.Dir)
+		}
+	}
 	return nil
 }
 
+// findDefinitions returns the definitions matching the patterns, using the cache if set.
+func (m *Matcher) findDefinitions(ap *ast.ASTProcessor, pkgName string, patterns []string) ([]ast.Match, error) {
+	// relative patterns and the main module depend on the directory
+	key := append([]string{"defs", m.dir, pkgName, m.settings(), strconv.FormatBool(ap.Interfaces)}, patterns...)
+	var defs []ast.Match
+	if m.Cache != nil && m.Cache.Get(key, &defs) {
+		m.debugPrintf("cached definitions for %s %s\n", pkgName, strings.Join(patterns, " "))
+		return defs, nil
+	}
+	defs, err := ap.FindFuncDefinitions(pkgName, patterns...)
+	if err != nil || m.Cache == nil {
+		return defs, err
+	}
+
+	// new functions may be added to any file of the packages searched, and
+	// wildcard patterns may match new packages.
+	var deps cache.Dependencies
+	dirs := make(map[string]bool)
+	for _, filename := range ap.DefinitionFiles() {
+		deps.Files = append(deps.Files, filename)
+		if dir := filepath.Dir(filename); !dirs[dir] {
+			dirs[dir] = true
+			deps.Dirs = append(deps.Dirs, dir)
+		}
+	}
+	deps.NewDirs = strings.Contains(pkgName, "...")
+	return defs, m.Cache.Put(key, defs, deps)
+}
+
+// findReferences returns the references to each definition found by gopls,
+// using the cache if set.  Up to Concurrency requests are sent at once.
+func (m *Matcher) findReferences(defs []ast.Match) ([][]gopls.Match, error) {
+	results := make([][]gopls.Match, len(defs))
+	var missing []int
+	for i, def := range defs {
+		if m.Cache != nil && m.Cache.Get(m.refsKey(def), &results[i]) {
+			m.debugPrintf("cached references to %s.%s\n", def.Pkg, def.MethodName())
+			continue
+		}
+		missing = append(missing, i)
+	}
+	if len(missing) == 0 {
+		return results, nil
+	}
+	pls, err := m.client()
+	if err != nil {
+		return nil, err
+	}
+
+	var g errgroup.Group
+	g.SetLimit(cmp.Or(m.Concurrency, 4))
+	for _, i := range missing {
+		def := defs[i]
+		g.Go(func() error {
+			matches, err := pls.FindReferences(def.Filename, def.OffsetLine, def.OffsetCol)
+			results[i] = uniqueMatches(matches)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	if m.Cache != nil {
+		for _, i := range missing {
+			// a reference can only be added by a change to a file naming the function.
+			deps := cache.Dependencies{Files: []string{defs[i].Filename}, Names: []string{defs[i].FuncName}}
+			for _, match := range results[i] {
+				if !slices.Contains(deps.Files, match.Filename) {
+					deps.Files = append(deps.Files, match.Filename)
+				}
+			}
+			if err := m.Cache.Put(m.refsKey(defs[i]), results[i], deps); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return results, nil
+}
+
+// refsKey returns the cache key for the references to a definition.
+func (m *Matcher) refsKey(def ast.Match) []string {
+	return []string{"refs", def.Filename, strconv.Itoa(def.OffsetLine), strconv.Itoa(def.OffsetCol), def.MethodName(), m.settings()}
+}
+
+// settings returns the settings that affect the definitions and references found.
+func (m *Matcher) settings() string {
+	return fmt.Sprintf("%d %t %s %s %s", m.Visibility, m.build.Tests, m.build.GOOS, m.build.GOARCH, strings.Join(m.build.Tags, ","))
+}
+
+// resolveBlame sets the commit that last modified each reference, running git
+// blame once for each file.
+func resolveBlame(refs []matchEntry) error {
+	blames := make(map[string][]git.BlameLine)
+	for i := range refs {
+		lines, ok := blames[refs[i].Filename]
+		if !ok {
+			var err error
+			if lines, err = git.Blame(refs[i].Filename); err != nil {
+				return err
+			}
+			blames[refs[i].Filename] = lines
+		}
+		if line := refs[i].Line; line >= 1 && line <= len(lines) {
+			refs[i].Blame = &lines[line-1]
+		}
+	}
+	return nil
+}
+
+func matchInstance(inst ast.Instance, patterns []string) bool {
+	for _, pattern := range patterns {
+		if inst.Match(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *Matcher) sort() {
 	slices.SortStableFunc(m.refs, func(a, b matchEntry) int {
 		if v := strings.Compare(a.Filename, b.Filename); v != 0 {
 			return v
 		}
-		return cmp.Compare(a.Line, b.Line)
+		if v := cmp.Compare(a.Line, b.Line); v != 0 {
+			return v
+		}
+		return cmp.Compare(a.Column, b.Column)
 	})
 }
 
@@ -235,3 +722,14 @@ func (m *Matcher) debug(f func()) {
 		f()
 	}
 }
+
+// uniqueMatches removes duplicate references, which gopls may report once for
+// each workspace folder containing them.
+func uniqueMatches(matches []gopls.Match) []gopls.Match {
+	seen := make(map[gopls.Match]bool, len(matches))
+	return slices.DeleteFunc(matches, func(match gopls.Match) bool {
+		dup := seen[match]
+		seen[match] = true
+		return dup
+	})
+}
@@ -0,0 +1,187 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy lists forbidden functions and methods, loaded by LoadPolicy.
+//
+// An example YAML policy:
+//
+//	rules:
+//	  - id: no-md5
+//	    package: crypto/md5
+//	    patterns: [New, Sum]
+//	    message: MD5 is not permitted; use crypto/sha256
+//	    severity: error
+//	    allow: ["legacy/**"]
+type Policy struct {
+	Rules []Rule `yaml:"rules" toml:"rules"`
+}
+
+// Rule forbids references to functions or methods within one or more packages.
+type Rule struct {
+	ID       string   `yaml:"id" toml:"id"`
+	Package  string   `yaml:"package" toml:"package"`   // comma-separated packages or patterns, as accepted by refs
+	Patterns []string `yaml:"patterns" toml:"patterns"` // function or Type.Method globs
+	Message  string   `yaml:"message" toml:"message"`
+	Severity string   `yaml:"severity" toml:"severity"` // error, warning or note; defaults to error
+	Allow    []string `yaml:"allow" toml:"allow"`       // path globs where references are permitted, relative as Record.File
+}
+
+// LoadPolicy reads a policy from a YAML or TOML file; files with a .toml extension
+// are parsed as TOML.
+func LoadPolicy(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if strings.EqualFold(filepath.Ext(filename), ".toml") {
+		err = toml.Unmarshal(data, &p)
+	} else {
+		err = yaml.Unmarshal(data, &p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing policy %s: %v", filename, err)
+	}
+	for i := range p.Rules {
+		if err := p.Rules[i].validate(i); err != nil {
+			return nil, fmt.Errorf("invalid policy %s: %v", filename, err)
+		}
+	}
+	return &p, nil
+}
+
+func (r *Rule) validate(index int) error {
+	if r.Package == "" || len(r.Patterns) == 0 {
+		return fmt.Errorf("rule %d must specify a package and at least one pattern", index+1)
+	}
+	if r.ID == "" {
+		r.ID = r.Package + "." + strings.Join(r.Patterns, ",")
+	}
+	if r.Message == "" {
+		r.Message = "forbidden reference"
+	}
+	switch r.Severity {
+	case "":
+		r.Severity = LevelError
+	case LevelError, LevelWarning, LevelNote:
+	default:
+		return fmt.Errorf("rule %s has invalid severity %q", r.ID, r.Severity)
+	}
+	return nil
+}
+
+// Violation is a reference forbidden by a policy rule.
+type Violation struct {
+	Rule      string `json:"rule"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+	Reference Record `json:"reference"`
+	ref       matchEntry
+	root      string // source root that SARIF locations and annotations are relative to
+}
+
+// Check finds references forbidden by each rule of the policy, excluding any
+// within the rule's allowed paths.  Matching references are also added to the
+// current match set.
+func (m *Matcher) Check(p *Policy) ([]Violation, error) {
+	var violations []Violation
+	for _, rule := range p.Rules {
+		first := len(m.refs)
+		if err := m.FindFuncReferences(rule.Package, rule.Patterns...); err != nil {
+			return nil, fmt.Errorf("rule %s: %v", rule.ID, err)
+		}
+		for _, me := range m.refs[first:] {
+			if matchAnyPath(rule.Allow, me.relFilename()) {
+				continue
+			}
+			violations = append(violations, Violation{
+				Rule:      rule.ID,
+				Severity:  rule.Severity,
+				Message:   rule.Message,
+				Reference: me.record(),
+				ref:       me,
+				root:      m.SourceRoot(),
+			})
+		}
+	}
+	return violations, nil
+}
+
+// WriteViolationsText writes violations in a compiler-like text format.
+func WriteViolationsText(w io.Writer, violations []Violation) error {
+	for _, v := range violations {
+		_, err := fmt.Fprintf(w, "%s:%d:%d: %s: %s [%s]\n    %s\n",
+			v.Reference.File, v.Reference.Line, v.Reference.Column, v.Severity, v.Message, v.Rule, v.ref.PrettySource)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteViolationsJson writes violations as a JSON array.
+func WriteViolationsJson(w io.Writer, violations []Violation) error {
+	if violations == nil {
+		violations = []Violation{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(violations)
+}
+
+// WriteViolationsSarif writes violations as a SARIF 2.1.0 log.
+func WriteViolationsSarif(w io.Writer, violations []Violation) error {
+	var root string
+	findings := make([]finding, len(violations))
+	for i, v := range violations {
+		root = v.root
+		findings[i] = finding{
+			rule:        v.Rule,
+			description: v.Message,
+			level:       v.Severity,
+			message:     fmt.Sprintf("%s: %s.%s", v.Message, v.ref.TargetPkg, v.ref.TargetSymbol),
+			ref:         v.ref,
+		}
+	}
+	return writeSarif(w, root, findings)
+}
+
+// WriteViolationsGithub writes violations as GitHub Actions workflow annotations,
+// with filenames relative to the root of the repository.
+func WriteViolationsGithub(w io.Writer, violations []Violation) error {
+	for _, v := range violations {
+		level := v.Severity
+		if level == LevelNote {
+			level = "notice"
+		}
+		_, err := fmt.Fprintf(w, "::%s file=%s,line=%d,endLine=%d,col=%d,endColumn=%d,title=%s::%s\n",
+			level, escapeAnnotationProperty(rootRelative(v.root, v.ref.Filename)), v.Reference.Line, v.Reference.EndLine, v.Reference.Column, v.Reference.EndColumn,
+			escapeAnnotationProperty(v.Rule), escapeAnnotationData(v.Message))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func escapeAnnotationData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func escapeAnnotationProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
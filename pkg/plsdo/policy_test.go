@@ -0,0 +1,128 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		want    []Rule
+		wantErr string
+	}{
+		{
+			name: "yaml",
+			file: "policy.yaml",
+			data: `rules:
+  - id: no-md5
+    package: crypto/md5
+    patterns: [New, Sum]
+    message: MD5 is not permitted
+    severity: warning
+    allow: ["legacy/**"]
+`,
+			want: []Rule{{ID: "no-md5", Package: "crypto/md5", Patterns: []string{"New", "Sum"},
+				Message: "MD5 is not permitted", Severity: LevelWarning, Allow: []string{"legacy/**"}}},
+		},
+		{
+			name: "toml",
+			file: "policy.TOML",
+			data: `[[rules]]
+package = "os/exec"
+patterns = ["Command"]
+`,
+			want: []Rule{{ID: "os/exec.Command", Package: "os/exec", Patterns: []string{"Command"},
+				Message: "forbidden reference", Severity: LevelError}},
+		},
+		{
+			name: "defaults",
+			file: "policy.yml",
+			data: "rules:\n  - package: io/ioutil\n    patterns: ['*', 'Read*']\n",
+			want: []Rule{{ID: "io/ioutil.*,Read*", Package: "io/ioutil", Patterns: []string{"*", "Read*"},
+				Message: "forbidden reference", Severity: LevelError}},
+		},
+		{
+			name:    "missing patterns",
+			file:    "policy.yaml",
+			data:    "rules:\n  - package: crypto/md5\n",
+			wantErr: "rule 1 must specify a package and at least one pattern",
+		},
+		{
+			name:    "invalid severity",
+			file:    "policy.yaml",
+			data:    "rules:\n  - id: x\n    package: crypto/md5\n    patterns: [New]\n    severity: fatal\n",
+			wantErr: `rule x has invalid severity "fatal"`,
+		},
+		{
+			name:    "invalid yaml",
+			file:    "policy.yaml",
+			data:    "rules: [",
+			wantErr: "error parsing policy",
+		},
+		{
+			name:    "yaml parsed as toml",
+			file:    "policy.toml",
+			data:    "rules:\n  - package: crypto/md5\n",
+			wantErr: "error parsing policy",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), tc.file)
+			if err := os.WriteFile(filename, []byte(tc.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			p, err := LoadPolicy(filename)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("LoadPolicy returned error %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p.Rules, tc.want) {
+				t.Errorf("LoadPolicy returned %+v, want %+v", p.Rules, tc.want)
+			}
+		})
+	}
+
+	if _, err := LoadPolicy(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("LoadPolicy of a missing file succeeded")
+	}
+}
+
+func TestWriteViolationsGithub(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+	me := matchEntry{
+		Filename: filepath.FromSlash("/repo/services/app/odd,name.go"),
+		FileRoot: filepath.FromSlash("/repo/services/app"),
+		Line:     3, Column: 9, EndLine: 3, EndColumn: 12,
+	}
+	violations := []Violation{{
+		Rule:      "no-md5",
+		Severity:  LevelNote,
+		Message:   "50% slower\nuse sha256",
+		Reference: me.record(),
+		ref:       me,
+		root:      root,
+	}}
+	var b strings.Builder
+	if err := WriteViolationsGithub(&b, violations); err != nil {
+		t.Fatal(err)
+	}
+	want := "::notice file=services/app/odd%2Cname.go,line=3,endLine=3,col=9,endColumn=12,title=no-md5::50%25 slower%0Ause sha256\n"
+	if b.String() != want {
+		t.Errorf("WriteViolationsGithub wrote:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gwatts/plsdo/pkg/ast"
+	"github.com/gwatts/plsdo/pkg/gopls"
+	"gopkg.in/yaml.v3"
+)
+
+// Query selects functions and methods to find references to.
+type Query struct {
+	Label    string   `json:"label" yaml:"label" toml:"label"`          // identifies the query in results
+	Package  string   `json:"package" yaml:"package" toml:"package"`    // comma-separated packages or patterns, as accepted by refs
+	Patterns []string `json:"patterns" yaml:"patterns" toml:"patterns"` // function or Type.Method globs
+}
+
+// LoadQueries reads a list of queries from a YAML or TOML file; files with a
+// .toml extension are parsed as TOML.  Queries without a label are labelled
+// with their package and patterns.
+//
+// An example YAML file:
+//
+//	queries:
+//	  - label: zap-sugar
+//	    package: go.uber.org/zap
+//	    patterns: [SugaredLogger.*]
+//	  - label: ioutil
+//	    package: io/ioutil
+//	    patterns: ["*"]
+func LoadQueries(filename string) ([]Query, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Queries []Query `yaml:"queries" toml:"queries"`
+	}
+	if strings.EqualFold(filepath.Ext(filename), ".toml") {
+		err = toml.Unmarshal(data, &file)
+	} else {
+		err = yaml.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing queries %s: %v", filename, err)
+	}
+	if len(file.Queries) == 0 {
+		return nil, fmt.Errorf("no queries found in %s", filename)
+	}
+	for i, q := range file.Queries {
+		if q.Package == "" || len(q.Patterns) == 0 {
+			return nil, fmt.Errorf("invalid queries %s: query %d must specify a package and at least one pattern", filename, i+1)
+		}
+		if q.Label == "" {
+			file.Queries[i].Label = q.Package + " " + strings.Join(q.Patterns, " ")
+		}
+	}
+	return file.Queries, nil
+}
+
+// Location is a range of source code.  Lines and columns are 1-based, and File
+// is relative to the Matcher's directory if within it.
+type Location struct {
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"end_line"`
+	EndColumn int    `json:"end_column"`
+	Filename  string `json:"-"` // absolute path of File
+}
+
+func (m *Matcher) newLocation(match gopls.Match) Location {
+	return Location{
+		File:      m.dirRelative(match.Filename),
+		Line:      match.StartLine,
+		Column:    match.StartCharacter,
+		EndLine:   match.EndLine,
+		EndColumn: match.EndCharacter,
+		Filename:  match.Filename,
+	}
+}
+
+// Definition is a function or method matched by a package and pattern.
+type Definition struct {
+	Package      string `json:"package"`
+	Symbol       string `json:"symbol"` // eg. Logger.Info
+	ReceiverType string `json:"receiver_type,omitempty"`
+	Rule         string `json:"rule"` // package and pattern that matched the symbol
+	File         string `json:"file"`
+	Line         int    `json:"line"`
+	Column       int    `json:"column"`
+	Filename     string `json:"-"`
+}
+
+func (m *Matcher) newDefinition(def ast.Match) Definition {
+	return Definition{
+		Package:      def.Pkg,
+		Symbol:       def.Symbol(),
+		ReceiverType: def.RecvType,
+		Rule:         def.Pkg + "." + def.Pattern,
+		File:         m.dirRelative(def.Filename),
+		Line:         def.OffsetLine,
+		Column:       def.OffsetCol,
+		Filename:     def.Filename,
+	}
+}
+
+// Implementation lists the implementations of an interface method, or the
+// interface methods implemented by a concrete method.
+type Implementation struct {
+	Definition      Definition `json:"definition"`
+	Implementations []Location `json:"implementations"`
+}
+
+// Symbol is a declaration found by FindSymbols.
+type Symbol struct {
+	Name     string   `json:"name"`
+	Kind     string   `json:"kind"`    // eg. function, method, struct, interface
+	Package  string   `json:"package"` // import path of the containing package
+	Location Location `json:"location"`
+}
+
+// FindDefinitions returns the functions and methods matching the patterns in the
+// named packages, as used by FindFuncReferences.
+func (m *Matcher) FindDefinitions(pkgName string, patterns ...string) ([]Definition, error) {
+	defs, err := m.findDefinitions(m.processor(false), pkgName, patterns)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Definition, len(defs))
+	for i, def := range defs {
+		result[i] = m.newDefinition(def)
+	}
+	return result, nil
+}
+
+// FindImplementations returns the implementations of the interface methods
+// matching the patterns in the named packages, and the interface methods
+// implemented by matching concrete methods.
+func (m *Matcher) FindImplementations(pkgName string, patterns ...string) ([]Implementation, error) {
+	defs, err := m.findDefinitions(m.processor(true), pkgName, patterns)
+	if err != nil {
+		return nil, err
+	}
+	pls, err := m.client()
+	if err != nil {
+		return nil, err
+	}
+	var result []Implementation
+	for _, def := range defs {
+		if def.RecvType == "" {
+			// functions have no implementations
+			continue
+		}
+		matches, err := pls.FindImplementations(def.Filename, def.OffsetLine, def.OffsetCol)
+		if err != nil {
+			return nil, err
+		}
+		impl := Implementation{Definition: m.newDefinition(def), Implementations: []Location{}}
+		for _, match := range matches {
+			impl.Implementations = append(impl.Implementations, m.newLocation(match))
+		}
+		slices.SortFunc(impl.Implementations, compareLocations)
+		result = append(result, impl)
+	}
+	return result, nil
+}
+
+// FindSymbols returns the declarations within the workspace and its dependencies
+// that match a query, using gopls' fuzzy symbol matching.
+func (m *Matcher) FindSymbols(query string) ([]Symbol, error) {
+	pls, err := m.client()
+	if err != nil {
+		return nil, err
+	}
+	symbols, err := pls.WorkspaceSymbols(query)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Symbol, len(symbols))
+	for i, sym := range symbols {
+		result[i] = Symbol{
+			Name:     sym.Name,
+			Kind:     sym.Kind,
+			Package:  sym.Container,
+			Location: m.newLocation(sym.Location),
+		}
+	}
+	return result, nil
+}
+
+func compareLocations(a, b Location) int {
+	return cmp.Or(
+		cmp.Compare(a.File, b.File),
+		cmp.Compare(a.Line, b.Line),
+		cmp.Compare(a.Column, b.Column),
+	)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadQueries(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		want    []Query
+		wantErr string
+	}{
+		{
+			name: "yaml",
+			file: "queries.yaml",
+			data: `queries:
+  - label: zap-sugar
+    package: go.uber.org/zap
+    patterns: [SugaredLogger.*]
+  - package: io/ioutil
+    patterns: ["*"]
+`,
+			want: []Query{
+				{Label: "zap-sugar", Package: "go.uber.org/zap", Patterns: []string{"SugaredLogger.*"}},
+				{Label: "io/ioutil *", Package: "io/ioutil", Patterns: []string{"*"}},
+			},
+		},
+		{
+			name: "toml",
+			file: "queries.Toml",
+			data: `[[queries]]
+package = "os/exec,os"
+patterns = ["Command", "StartProcess"]
+`,
+			want: []Query{{Label: "os/exec,os Command StartProcess", Package: "os/exec,os",
+				Patterns: []string{"Command", "StartProcess"}}},
+		},
+		{
+			name:    "empty",
+			file:    "queries.yaml",
+			data:    "queries: []\n",
+			wantErr: "no queries found",
+		},
+		{
+			name:    "missing package",
+			file:    "queries.yaml",
+			data:    "queries:\n  - label: x\n    patterns: [New]\n",
+			wantErr: "query 1 must specify a package and at least one pattern",
+		},
+		{
+			name:    "missing patterns",
+			file:    "queries.yaml",
+			data:    "queries:\n  - package: crypto/md5\n  - package: os\n",
+			wantErr: "query 1 must specify a package and at least one pattern",
+		},
+		{
+			name:    "invalid yaml",
+			file:    "queries.yml",
+			data:    "queries: [",
+			wantErr: "error parsing queries",
+		},
+		{
+			name:    "yaml parsed as toml",
+			file:    "queries.toml",
+			data:    "queries:\n  - package: crypto/md5\n",
+			wantErr: "error parsing queries",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), tc.file)
+			if err := os.WriteFile(filename, []byte(tc.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			queries, err := LoadQueries(filename)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("LoadQueries returned error %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(queries, tc.want) {
+				t.Errorf("LoadQueries returned %+v, want %+v", queries, tc.want)
+			}
+		})
+	}
+
+	if _, err := LoadQueries(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("LoadQueries of a missing file succeeded")
+	}
+}
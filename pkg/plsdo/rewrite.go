@@ -0,0 +1,292 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gwatts/plsdo/pkg/ast"
+)
+
+// FileChange holds the result of rewriting the matched calls within a single file.
+type FileChange struct {
+	File     string // Filename relative to the Matcher's directory if within it
+	Filename string // absolute path of the file
+	Before   []byte
+	After    []byte
+	Count    int // number of calls rewritten
+}
+
+// RewriteSkip is a matched reference that Rewrite could not rewrite.
+type RewriteSkip struct {
+	File     string // Filename relative to the Matcher's directory if within it
+	Filename string
+	Line     int
+	Column   int
+	Reason   string // eg. "not called"
+}
+
+// Rewrite applies a rewrite rule to every matched call, returning the files that
+// changed and the references that could not be rewritten.  Files on disk are not
+// modified; see FileChange.Write.
+func (m *Matcher) Rewrite(rule *ast.Rewrite) ([]FileChange, []RewriteSkip, error) {
+	m.sort()
+	ap := ast.NewASTProcessor()
+
+	var (
+		changes []FileChange
+		skips   []RewriteSkip
+		files   []string
+	)
+	refs := make(map[string][]ast.Position)
+	for _, ref := range m.refs {
+		if _, ok := refs[ref.Filename]; !ok {
+			files = append(files, ref.Filename)
+		}
+		refs[ref.Filename] = append(refs[ref.Filename], ast.Position{Line: ref.Line, Column: ref.Column})
+	}
+
+	for _, filename := range files {
+		before, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		after, count, skipped, err := ap.RewriteFile(filename, rule, refs[filename])
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, skip := range skipped {
+			skips = append(skips, RewriteSkip{File: m.dirRelative(filename), Filename: filename, Line: skip.Line, Column: skip.Column, Reason: skip.Reason})
+		}
+		if count == 0 || bytes.Equal(before, after) {
+			continue
+		}
+		changes = append(changes, FileChange{File: m.dirRelative(filename), Filename: filename, Before: before, After: after, Count: count})
+	}
+	return changes, skips, nil
+}
+
+// Write replaces the file on disk with the rewritten source.
+func (fc FileChange) Write() error {
+	info, err := os.Stat(fc.Filename)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fc.Filename, fc.After, info.Mode().Perm())
+}
+
+// Diff returns a unified diff of the change, naming the file by File where set.
+func (fc FileChange) Diff() string {
+	name := strings.TrimPrefix(filepath.ToSlash(cmp.Or(fc.File, fc.Filename)), "/")
+	return unifiedDiff("a/"+name, "b/"+name, fc.Before, fc.After)
+}
+
+const diffContext = 3
+
+// unifiedDiff produces a unified diff between two texts.
+func unifiedDiff(fromName, toName string, from, to []byte) string {
+	ops := diffLines(splitLines(from), splitLines(to), nil)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for start := 0; start < len(ops); {
+		// find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// extend the hunk until a run of unchanged lines longer than twice the context
+		end := start
+		for unchanged := 0; end < len(ops) && unchanged <= 2*diffContext; end++ {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		hunkStart := max(start-diffContext, 0)
+		hunkEnd := end
+		for hunkEnd > start && ops[hunkEnd-1].kind == ' ' {
+			hunkEnd--
+		}
+		hunkEnd = min(hunkEnd+diffContext, len(ops))
+
+		// line numbers at the start of the hunk
+		fromLine, toLine := 1, 1
+		for _, o := range ops[:hunkStart] {
+			if o.kind != '+' {
+				fromLine++
+			}
+			if o.kind != '-' {
+				toLine++
+			}
+		}
+		var fromCount, toCount int
+		for _, o := range ops[hunkStart:hunkEnd] {
+			if o.kind != '+' {
+				fromCount++
+			}
+			if o.kind != '-' {
+				toCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", fromLine, fromCount, toLine, toCount)
+		for _, o := range ops[hunkStart:hunkEnd] {
+			fmt.Fprintf(&out, "%c%s\n", o.kind, o.text)
+		}
+		start = hunkEnd
+	}
+	return out.String()
+}
+
+// diffOp is a line of an edit script.
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+// diffLines appends to ops a minimal edit script transforming a into b, using
+// Myers' algorithm in linear space, so large files don't need a table of every
+// pair of lines.
+func diffLines(a, b []string, ops []diffOp) []diffOp {
+	// trim the common prefix and suffix, which is usually most of the file
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	x, y := -1, -1
+	if shareLine(ma, mb) {
+		x, y = middleSnake(ma, mb)
+	}
+	if x < 0 {
+		for _, line := range ma {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range mb {
+			ops = append(ops, diffOp{'+', line})
+		}
+	} else {
+		ops = diffLines(ma[:x], mb[:y], ops)
+		ops = diffLines(ma[x:], mb[y:], ops)
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// shareLine reports whether any line is in both a and b.  Unrelated texts are the
+// slowest case for middleSnake, which searches every diagonal before giving up.
+func shareLine(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	lines := make(map[string]bool, len(a))
+	for _, line := range a {
+		lines[line] = true
+	}
+	return slices.ContainsFunc(b, func(line string) bool { return lines[line] })
+}
+
+// middleSnake returns a point at which an edit script between a and b may be
+// split in two, found by searching forwards from the start and backwards from
+// the end until the paths overlap, or -1, -1 if a and b have no line in common.
+// a and b must be non-empty and differ in their first and last lines.
+func middleSnake(a, b []string) (x, y int) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	// fwd[offset+k] and rev[offset+k] are the furthest x reached on diagonal k
+	// searching forwards and backwards
+	fwd := make([]int, 2*maxD+2)
+	rev := make([]int, 2*maxD+2)
+	for i := range fwd {
+		fwd[i], rev[i] = -1, -1
+	}
+	fwd[offset+1], rev[offset+1] = 0, 0
+	delta := n - m
+	// the paths overlap in the forward search if delta is odd
+	front := delta%2 != 0
+	// diagonals that have run off the edge of the grid are not searched again
+	var fwdStart, fwdEnd, revStart, revEnd int
+	for d := 0; d < maxD; d++ {
+		for k := -d + fwdStart; k <= d-fwdEnd; k += 2 {
+			i := offset + k
+			var x1 int
+			if k == -d || (k != d && fwd[i-1] < fwd[i+1]) {
+				x1 = fwd[i+1]
+			} else {
+				x1 = fwd[i-1] + 1
+			}
+			y1 := x1 - k
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			fwd[i] = x1
+			switch {
+			case x1 > n:
+				fwdEnd += 2
+			case y1 > m:
+				fwdStart += 2
+			case front:
+				if j := offset + delta - k; j >= 0 && j < len(rev) && rev[j] != -1 && x1 >= n-rev[j] {
+					return x1, y1
+				}
+			}
+		}
+		for k := -d + revStart; k <= d-revEnd; k += 2 {
+			i := offset + k
+			var x2 int
+			if k == -d || (k != d && rev[i-1] < rev[i+1]) {
+				x2 = rev[i+1]
+			} else {
+				x2 = rev[i-1] + 1
+			}
+			y2 := x2 - k
+			for x2 < n && y2 < m && a[n-1-x2] == b[m-1-y2] {
+				x2++
+				y2++
+			}
+			rev[i] = x2
+			switch {
+			case x2 > n:
+				revEnd += 2
+			case y2 > m:
+				revStart += 2
+			case !front:
+				if j := offset + delta - k; j >= 0 && j < len(fwd) && fwd[j] != -1 && fwd[j] >= n-x2 {
+					return fwd[j], fwd[j] - (j - offset)
+				}
+			}
+		}
+	}
+	return -1, -1
+}
+
+func splitLines(b []byte) []string {
+	s := strings.TrimSuffix(string(b), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
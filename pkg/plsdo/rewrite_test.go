@@ -0,0 +1,156 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// numbered returns lines "1" to "n", replacing those in edits.
+func numbered(n int, edits map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		line, ok := edits[i]
+		if !ok {
+			line = fmt.Sprint(i)
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{
+			name: "unchanged",
+			from: numbered(5, nil),
+			to:   numbered(5, nil),
+			want: "",
+		},
+		{
+			name: "changed line",
+			from: numbered(10, nil),
+			to:   numbered(10, map[int]string{5: "five"}),
+			want: "@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "insertion at start",
+			from: "b\nc\n",
+			to:   "a\nb\nc\n",
+			want: "@@ -1,2 +1,3 @@\n+a\n b\n c\n",
+		},
+		{
+			name: "deletion at end",
+			from: numbered(6, nil),
+			to:   numbered(5, nil),
+			want: "@@ -3,4 +3,3 @@\n 3\n 4\n 5\n-6\n",
+		},
+		{
+			name: "nearby changes share a hunk",
+			from: numbered(12, nil),
+			to:   numbered(12, map[int]string{3: "three", 9: "nine"}),
+			want: "@@ -1,12 +1,12 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n 7\n 8\n-9\n+nine\n 10\n 11\n 12\n",
+		},
+		{
+			name: "distant changes have separate hunks",
+			from: numbered(20, nil),
+			to:   numbered(20, map[int]string{2: "two", 18: "eighteen"}),
+			want: "@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+				"@@ -15,6 +15,6 @@\n 15\n 16\n 17\n-18\n+eighteen\n 19\n 20\n",
+		},
+		{
+			name: "line split in two",
+			from: "a\nf(x, y)\nb\n",
+			to:   "a\nf(x,\n\ty)\nb\n",
+			want: "@@ -1,3 +1,4 @@\n a\n-f(x, y)\n+f(x,\n+\ty)\n b\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			want := "--- a/x.go\n+++ b/x.go\n" + tc.want
+			if got := unifiedDiff("a/x.go", "b/x.go", []byte(tc.from), []byte(tc.to)); got != want {
+				t.Errorf("unifiedDiff returned:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	random := func(n int) []string {
+		lines := make([]string, n)
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.IntN(4)))
+		}
+		return lines
+	}
+	for i := 0; i < 500; i++ {
+		a, b := random(rng.IntN(12)), random(rng.IntN(12))
+		ops := diffLines(a, b, nil)
+		var gotA, gotB []string
+		edits := 0
+		for _, o := range ops {
+			if o.kind != '+' {
+				gotA = append(gotA, o.text)
+			}
+			if o.kind != '-' {
+				gotB = append(gotB, o.text)
+			}
+			if o.kind != ' ' {
+				edits++
+			}
+		}
+		if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+			t.Fatalf("diffLines(%q, %q) = %v does not transform one into the other", a, b, ops)
+		}
+		if want := len(a) + len(b) - 2*lcsLength(a, b); edits != want {
+			t.Fatalf("diffLines(%q, %q) = %v has %d edits, want %d", a, b, ops, edits, want)
+		}
+	}
+}
+
+// lcsLength returns the length of the longest common subsequence of a and b.
+func lcsLength(a, b []string) int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// TestUnifiedDiffLarge checks that large changes don't need memory or time
+// proportional to the product of the file lengths.
+func TestUnifiedDiffLarge(t *testing.T) {
+	const n = 20000
+	from := numbered(n, nil)
+	to := numbered(n, map[int]string{1: "one", n: "last"})
+	got := unifiedDiff("a/x.go", "b/x.go", []byte(from), []byte(to))
+	if want := 2; strings.Count(got, "@@ -") != want {
+		t.Errorf("unifiedDiff returned %d hunks, want %d", strings.Count(got, "@@ -"), want)
+	}
+
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "x%d\n", i)
+	}
+	got = unifiedDiff("a/x.go", "b/x.go", []byte(from), []byte(b.String()))
+	if lines := strings.Count(got, "\n"); lines != 2*n+3 {
+		t.Errorf("unifiedDiff of unrelated files returned %d lines, want %d", lines, 2*n+3)
+	}
+}
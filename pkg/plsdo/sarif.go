@@ -0,0 +1,177 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	toolName     = "plsdo"
+	toolURI      = "https://github.com/gwatts/plsdo"
+)
+
+// SARIF severity levels.
+const (
+	LevelError   = "error"
+	LevelWarning = "warning"
+	LevelNote    = "note"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                        `json:"tool"`
+	OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds,omitempty"`
+	Results            []sarifResult                    `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string       `json:"id"`
+	ShortDescription     sarifMessage `json:"shortDescription"`
+	DefaultConfiguration sarifConfig  `json:"defaultConfiguration"`
+}
+
+type sarifConfig struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int           `json:"startLine"`
+	StartColumn int           `json:"startColumn"`
+	EndLine     int           `json:"endLine"`
+	EndColumn   int           `json:"endColumn"`
+	Snippet     *sarifMessage `json:"snippet,omitempty"`
+}
+
+// srcRootID is the base of the artifact locations in SARIF output.
+const srcRootID = "%SRCROOT%"
+
+// finding is a reference reported against a rule.
+type finding struct {
+	rule        string
+	description string
+	level       string
+	message     string
+	ref         matchEntry
+}
+
+// Sarif outputs matches as a SARIF 2.1.0 log, suitable for upload to code scanning
+// dashboards.  A rule is reported for each package and pattern that matched.
+func (m *Matcher) Sarif(w io.Writer) error {
+	m.sort()
+	findings := make([]finding, len(m.refs))
+	for i, me := range m.refs {
+		findings[i] = finding{
+			rule:        me.RuleID,
+			description: fmt.Sprintf("References to %s", me.RuleID),
+			level:       LevelWarning,
+			message:     fmt.Sprintf("Reference to %s.%s", me.TargetPkg, me.TargetSymbol),
+			ref:         me,
+		}
+	}
+	return writeSarif(w, m.SourceRoot(), findings)
+}
+
+// writeSarif writes findings with locations relative to root, the directory
+// identified by %SRCROOT%; root is empty only if there are no findings.
+func writeSarif(w io.Writer, root string, findings []finding) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           toolName,
+			InformationURI: toolURI,
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	if root != "" {
+		run.OriginalURIBaseIDs = map[string]sarifArtifactLocation{
+			srcRootID: {URI: (&url.URL{Scheme: "file", Path: filepath.ToSlash(root) + "/"}).String()},
+		}
+	}
+	seen := make(map[string]bool)
+	for _, f := range findings {
+		if !seen[f.rule] {
+			seen[f.rule] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+				ID:                   f.rule,
+				ShortDescription:     sarifMessage{Text: f.description},
+				DefaultConfiguration: sarifConfig{Level: f.level},
+			})
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:  f.rule,
+			Level:   f.level,
+			Message: sarifMessage{Text: f.message},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{
+						URI:       rootRelative(root, f.ref.Filename),
+						URIBaseID: srcRootID,
+					},
+					Region: sarifRegion{
+						StartLine:   f.ref.Line,
+						StartColumn: f.ref.Column,
+						EndLine:     f.ref.EndLine,
+						EndColumn:   f.ref.EndColumn,
+						Snippet:     &sarifMessage{Text: f.ref.OrgSource},
+					},
+				},
+			}},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs:    []sarifRun{run},
+	})
+}
@@ -0,0 +1,187 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// SchemaVersion is the version of the Record schema used for JSON output.
+// Fields may be added within a version, but will not be renamed, removed or
+// change meaning without the version being incremented.
+const SchemaVersion = 1
+
+// Record is the machine-readable representation of a single reference.
+//
+// Lines and columns are 1-based.  File is slash-separated and relative to the
+// directory of the go.work file in use, or otherwise to the root of the module
+// containing the reference.
+type Record struct {
+	Schema      int             `json:"schema"`
+	File        string          `json:"file"`
+	Line        int             `json:"line"`
+	Column      int             `json:"column"`
+	EndLine     int             `json:"end_line"`
+	EndColumn   int             `json:"end_column"`
+	Target      TargetRecord    `json:"target"`
+	Caller      CallerRecord    `json:"caller"`
+	Enclosing   EnclosingRecord `json:"enclosing"`
+	Source      SourceRecord    `json:"source"`
+	Test        bool            `json:"test"`
+	Instance    string          `json:"instance,omitempty"`
+	Args        []ArgRecord     `json:"args,omitempty"`
+	Blame       *BlameRecord    `json:"blame,omitempty"`
+	Label       string          `json:"label,omitempty"` // label of the query that found the reference
+	Fingerprint string          `json:"fingerprint"`     // identifies the reference independently of its position
+	Filename    string          `json:"-"`               // absolute path of File; not included in JSON output
+}
+
+// TargetRecord identifies the function or method being referenced.
+type TargetRecord struct {
+	Package string `json:"package"` // import path, eg. go.uber.org/zap
+	Symbol  string `json:"symbol"`  // function name, or type and method name, eg. Logger.Info
+	Rule    string `json:"rule"`    // package and pattern that matched the symbol, eg. go.uber.org/zap.Logger.*
+}
+
+// CallerRecord identifies the package and module containing the reference.
+type CallerRecord struct {
+	Package     string `json:"package"`      // import path
+	PackageName string `json:"package_name"` // package name as declared in the source
+	Module      string `json:"module"`       // module path
+}
+
+// EnclosingRecord describes the function containing the reference.
+type EnclosingRecord struct {
+	Function     string   `json:"function"`                // named function or method, or "global scope"
+	ReceiverType string   `json:"receiver_type,omitempty"` // eg. *Server
+	ReceiverName string   `json:"receiver_name,omitempty"`
+	Chain        []string `json:"chain"` // full lexical chain, eg. ["(*Server).Start", "func literal #1", "goroutine"]
+	Go           bool     `json:"go"`    // run by the goroutine of a go statement
+	Defer        bool     `json:"defer"` // run by the call of a defer statement
+}
+
+// SourceRecord holds the extracted source code of the reference.
+type SourceRecord struct {
+	Text      string   `json:"text"`      // source as it appears in the file
+	Formatted string   `json:"formatted"` // reformatted source
+	StartLine int      `json:"start_line"`
+	EndLine   int      `json:"end_line"`
+	Before    []string `json:"before,omitempty"` // context lines preceding the source
+	After     []string `json:"after,omitempty"`  // context lines following the source
+}
+
+// BlameRecord identifies the commit that last modified the reference.
+type BlameRecord struct {
+	Commit      string `json:"commit"` // all zeros if not yet committed
+	Author      string `json:"author"`
+	AuthorEmail string `json:"author_email"`
+	Date        string `json:"date"` // RFC 3339 author date
+}
+
+// ArgRecord describes an argument of a matched call.
+type ArgRecord struct {
+	Source   string `json:"source"`
+	Kind     string `json:"kind"` // literal, ident, call, composite, selector, func, unary, binary or expr
+	Type     string `json:"type,omitempty"`
+	Constant bool   `json:"constant"`
+}
+
+// Records returns the current matches in their machine-readable form.
+func (m *Matcher) Records() []Record {
+	m.sort()
+	records := make([]Record, len(m.refs))
+	for i, me := range m.refs {
+		records[i] = me.record()
+	}
+	return records
+}
+
+// Json outputs matches as a JSON array of Records.
+func (m *Matcher) Json(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m.Records())
+}
+
+// JsonLines outputs matches as JSON Lines, with one Record per line.
+func (m *Matcher) JsonLines(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, rec := range m.Records() {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (me matchEntry) record() Record {
+	rec := Record{
+		Schema:    SchemaVersion,
+		File:      me.relFilename(),
+		Line:      me.Line,
+		Column:    me.Column,
+		EndLine:   me.EndLine,
+		EndColumn: me.EndColumn,
+		Target: TargetRecord{
+			Package: me.TargetPkg,
+			Symbol:  me.TargetSymbol,
+			Rule:    me.RuleID,
+		},
+		Caller: CallerRecord{
+			Package:     me.CallerPkg,
+			PackageName: me.Package,
+			Module:      me.CallerModule,
+		},
+		Enclosing: EnclosingRecord{
+			Function:     me.EncFuncName,
+			ReceiverType: me.EncRecvType,
+			ReceiverName: me.EncRecvName,
+			Chain:        me.EncChain,
+			Go:           me.InGo,
+			Defer:        me.InDefer,
+		},
+		Source: SourceRecord{
+			Text:      me.OrgSource,
+			Formatted: me.PrettySource,
+			StartLine: me.SrcStartLine,
+			EndLine:   me.SrcEndLine,
+			Before:    me.Before,
+			After:     me.After,
+		},
+		Test:     me.InTest,
+		Instance: me.Instance,
+		Label:    me.Label,
+		Filename: me.Filename,
+	}
+	if me.Blame != nil {
+		rec.Blame = &BlameRecord{
+			Commit:      me.Blame.Commit,
+			Author:      me.Blame.Author,
+			AuthorEmail: me.Blame.AuthorEmail,
+			Date:        me.Blame.Time.Format(time.RFC3339),
+		}
+	}
+	rec.Fingerprint = rec.fingerprint()
+	for _, arg := range me.Args {
+		rec.Args = append(rec.Args, ArgRecord{
+			Source:   arg.Source,
+			Kind:     arg.Kind,
+			Type:     arg.Type,
+			Constant: arg.Constant,
+		})
+	}
+	return rec
+}
+
+// relFilename returns the filename relative to the go.work directory or the root
+// of its module, as Record.File, if known.
+func (me matchEntry) relFilename() string {
+	if me.FileRoot == "" {
+		return filepath.ToSlash(me.Filename)
+	}
+	return rootRelative(me.FileRoot, me.Filename)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Dimensions by which Stats groups matches.
+const (
+	BySymbol   = "symbol"   // referenced function or method, eg. go.uber.org/zap.Logger.Info
+	ByPackage  = "package"  // import path of the calling package
+	ByFile     = "file"     // file containing the reference
+	ByFunction = "function" // enclosing function, qualified by its package
+	ByLabel    = "label"    // label of the query that found the reference
+	ByModule   = "module"   // path of the module containing the reference
+)
+
+// Stats sort orders.
+const (
+	SortCount = "count" // descending count, the default
+	SortName  = "name"  // ascending key
+)
+
+// StatsDimensions lists every dimension supported by Stats, in their default order.
+// ByLabel is only included by default if the matches were found by labelled queries,
+// and ByModule only if the workspace has more than one module.
+var StatsDimensions = []string{ByLabel, BySymbol, ByModule, ByPackage, ByFile, ByFunction}
+
+// StatsOptions controls how Stats aggregates matches.
+type StatsOptions struct {
+	By   []string // dimensions to group by; defaults to StatsDimensions
+	Top  int      // maximum rows reported for each dimension; 0 for all
+	Sort string   // SortCount or SortName
+}
+
+// Stats holds match counts aggregated by one or more dimensions.
+type Stats struct {
+	Total  int          `json:"total"`
+	Groups []StatsGroup `json:"groups"`
+}
+
+// StatsGroup holds the match counts for a single dimension.
+type StatsGroup struct {
+	By       string     `json:"by"`
+	Distinct int        `json:"distinct"` // number of distinct keys, before applying Top
+	Rows     []StatsRow `json:"rows"`
+}
+
+// StatsRow is the number of matches sharing a key.
+type StatsRow struct {
+	Key     string  `json:"key"`
+	Count   int     `json:"count"`
+	Percent float64 `json:"percent"` // percentage of all matches
+}
+
+// Stats aggregates the current matches.
+func (m *Matcher) Stats(opts StatsOptions) (Stats, error) {
+	dims := opts.By
+	if len(dims) == 0 {
+		dims = slices.DeleteFunc(slices.Clone(StatsDimensions), func(dim string) bool {
+			return (dim == ByLabel && !m.labelled()) || (dim == ByModule && !m.ws.multiModule())
+		})
+	}
+	switch opts.Sort {
+	case "", SortCount, SortName:
+	default:
+		return Stats{}, fmt.Errorf("invalid sort order %q; must be %s or %s", opts.Sort, SortCount, SortName)
+	}
+
+	stats := Stats{Total: len(m.refs), Groups: []StatsGroup{}}
+	for _, dim := range dims {
+		counts := make(map[string]int)
+		for _, me := range m.refs {
+			key, err := me.statsKey(dim)
+			if err != nil {
+				return Stats{}, err
+			}
+			counts[key]++
+		}
+
+		group := StatsGroup{By: dim, Distinct: len(counts), Rows: []StatsRow{}}
+		for key, count := range counts {
+			group.Rows = append(group.Rows, StatsRow{
+				Key:     key,
+				Count:   count,
+				Percent: 100 * float64(count) / float64(stats.Total),
+			})
+		}
+		slices.SortFunc(group.Rows, func(a, b StatsRow) int {
+			if opts.Sort != SortName {
+				if c := cmp.Compare(b.Count, a.Count); c != 0 {
+					return c
+				}
+			}
+			return cmp.Compare(a.Key, b.Key)
+		})
+		if opts.Top > 0 && len(group.Rows) > opts.Top {
+			group.Rows = group.Rows[:opts.Top]
+		}
+		stats.Groups = append(stats.Groups, group)
+	}
+	return stats, nil
+}
+
+func (me matchEntry) statsKey(dim string) (string, error) {
+	switch dim {
+	case BySymbol:
+		return me.TargetPkg + "." + me.TargetSymbol, nil
+	case ByPackage:
+		return cmp.Or(me.CallerPkg, me.Package), nil
+	case ByFile:
+		return me.relFilename(), nil
+	case ByLabel:
+		return me.Label, nil
+	case ByModule:
+		return me.CallerModule, nil
+	case ByFunction:
+		fn := me.EncFuncName
+		if len(me.EncChain) > 0 {
+			fn = me.EncChain[0]
+		}
+		return cmp.Or(me.CallerPkg, me.Package) + "." + fn, nil
+	}
+	return "", fmt.Errorf("invalid stats dimension %q; must be one of %s", dim, strings.Join(StatsDimensions, ", "))
+}
+
+// WriteTable writes the statistics as aligned text tables, one per dimension.
+func (s Stats) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "Total references: %d\n", s.Total)
+	for _, group := range s.Groups {
+		fmt.Fprintf(tw, "\nBy %s (%d of %d)\n", group.By, len(group.Rows), group.Distinct)
+		fmt.Fprintf(tw, "COUNT\tPERCENT\t %s\n", strings.ToUpper(group.By))
+		for _, row := range group.Rows {
+			fmt.Fprintf(tw, "%d\t%.1f%%\t %s\n", row.Count, row.Percent, row.Key)
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteJson writes the statistics as a JSON object.
+func (s Stats) WriteJson(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// TemplateFuncs returns the helper functions available to templates used by
+// TemplateFormatter, in addition to the text/template builtins.
+//
+// Functions taking a string as their last argument may be used in pipelines,
+// eg. {{.Enclosing.Chain | join " > " | upper}}.
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"join":      func(sep string, elems []string) string { return strings.Join(elems, sep) },
+		"upper":     strings.ToUpper,
+		"lower":     strings.ToLower,
+		"trim":      strings.TrimSpace,
+		"replace":   func(old, repl, s string) string { return strings.ReplaceAll(s, old, repl) },
+		"contains":  func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix": func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix": func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"oneline":   func(s string) string { return strings.Join(strings.Fields(s), " ") },
+		"indent":    indent,
+		"pad":       func(width int, s string) string { return fmt.Sprintf("%-*s", width, s) },
+		"quote":     strconv.Quote,
+		"base":      path.Base,
+		"dir":       path.Dir,
+		"json":      toJson,
+	}
+}
+
+func indent(n int, s string) string {
+	prefix := strings.Repeat(" ", n)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func toJson(v any) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// TemplateFormatter is a Formatter that renders matches through a text/template.
+type TemplateFormatter struct {
+	tmpl *template.Template
+	all  bool
+}
+
+// NewTemplateFormatter parses text as a template with TemplateFuncs available.
+//
+// If all is false, the template is executed once for each match with its Record,
+// and each result is followed by a newline, in the manner of go list -f.  If all is
+// true, the template is executed once with a []Record holding every match.
+func NewTemplateFormatter(text string, all bool) (*TemplateFormatter, error) {
+	tmpl, err := template.New("plsdo").Funcs(TemplateFuncs()).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateFormatter{tmpl: tmpl, all: all}, nil
+}
+
+// Format implements Formatter.
+func (t *TemplateFormatter) Format(w io.Writer, m *Matcher, opts FormatOptions) error {
+	records := m.Records()
+	if t.all {
+		return t.tmpl.Execute(w, records)
+	}
+	for _, rec := range records {
+		if err := t.tmpl.Execute(w, rec); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
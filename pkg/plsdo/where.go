@@ -0,0 +1,104 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gwatts/plsdo/pkg/ast"
+	"github.com/ryanuber/go-glob"
+)
+
+// Condition is a filter on the arguments of a matched call, parsed by ParseCondition.
+type Condition struct {
+	Index    int    // argument index for arg[N] conditions
+	Field    string // source, kind, type, const or argc
+	Negate   bool   // true for !=
+	Value    string // glob to match
+	Contains bool   // true for "args contains <value>"
+}
+
+var (
+	argCondRe      = regexp.MustCompile(`^arg\[(\d+)\]\.(source|kind|type|const)\s*(!=|=)\s*(.*)$`)
+	argcCondRe     = regexp.MustCompile(`^argc\s*(!=|=)\s*(\d+)$`)
+	containsCondRe = regexp.MustCompile(`^args\s+contains\s+(.+)$`)
+)
+
+// ParseCondition parses a filter expression on call arguments.  Supported forms are:
+//
+//	arg[N].kind=literal     argument N is of the given kind (literal, ident, call, composite, ...)
+//	arg[N].type=*zap.Field  argument N has a type matching the glob
+//	arg[N].source!="msg*"   argument N has source code not matching the glob
+//	arg[N].const=false      argument N is not a compile-time constant
+//	argc=2                  the call has exactly 2 arguments
+//	args contains zap.Error at least one argument is, calls or has a type matching the value
+func ParseCondition(expr string) (Condition, error) {
+	expr = strings.TrimSpace(expr)
+	if m := argCondRe.FindStringSubmatch(expr); m != nil {
+		idx, _ := strconv.Atoi(m[1])
+		return Condition{Index: idx, Field: m[2], Negate: m[3] == "!=", Value: unquote(m[4])}, nil
+	}
+	if m := argcCondRe.FindStringSubmatch(expr); m != nil {
+		return Condition{Field: "argc", Negate: m[1] == "!=", Value: m[2]}, nil
+	}
+	if m := containsCondRe.FindStringSubmatch(expr); m != nil {
+		return Condition{Contains: true, Value: unquote(m[1])}, nil
+	}
+	return Condition{}, fmt.Errorf("invalid condition %q", expr)
+}
+
+// Match reports whether the arguments of a call satisfy the condition.
+func (c Condition) Match(args []ast.Arg) bool {
+	if c.Contains {
+		for _, arg := range args {
+			if arg.Source == c.Value || strings.HasPrefix(arg.Source, c.Value+"(") ||
+				glob.Glob(c.Value, arg.Source) || glob.Glob(c.Value, arg.Type) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var value string
+	switch c.Field {
+	case "argc":
+		value = strconv.Itoa(len(args))
+	default:
+		if c.Index >= len(args) {
+			return false
+		}
+		arg := args[c.Index]
+		switch c.Field {
+		case "source":
+			value = arg.Source
+		case "kind":
+			value = arg.Kind
+		case "type":
+			value = arg.Type
+		case "const":
+			value = strconv.FormatBool(arg.Constant)
+		}
+	}
+	return glob.Glob(c.Value, value) != c.Negate
+}
+
+func matchConditions(conds []Condition, args []ast.Arg) bool {
+	for _, cond := range conds {
+		if !cond.Match(args) {
+			return false
+		}
+	}
+	return true
+}
+
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"testing"
+
+	"github.com/gwatts/plsdo/pkg/ast"
+)
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    Condition
+		wantErr bool
+	}{
+		{expr: "arg[0].kind=literal", want: Condition{Index: 0, Field: "kind", Value: "literal"}},
+		{expr: "arg[2].type = *zap.Field", want: Condition{Index: 2, Field: "type", Value: "*zap.Field"}},
+		{expr: `arg[1].source!="msg*"`, want: Condition{Index: 1, Field: "source", Negate: true, Value: "msg*"}},
+		{expr: "arg[0].const=false", want: Condition{Field: "const", Value: "false"}},
+		{expr: " argc=2 ", want: Condition{Field: "argc", Value: "2"}},
+		{expr: "argc != 0", want: Condition{Field: "argc", Negate: true, Value: "0"}},
+		{expr: "args contains zap.Error", want: Condition{Contains: true, Value: "zap.Error"}},
+		{expr: `args contains "ctx"`, want: Condition{Contains: true, Value: "ctx"}},
+		{expr: "arg[x].kind=literal", wantErr: true},
+		{expr: "arg[0].name=x", wantErr: true},
+		{expr: "argc=two", wantErr: true},
+		{expr: "", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := ParseCondition(tc.expr)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParseCondition(%q) = %+v, want error", tc.expr, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCondition(%q) failed: %v", tc.expr, err)
+		} else if got != tc.want {
+			t.Errorf("ParseCondition(%q) = %+v, want %+v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestConditionMatch(t *testing.T) {
+	args := []ast.Arg{
+		{Source: `"started"`, Kind: ast.ArgLiteral, Type: "string", Constant: true},
+		{Source: "zap.Error(err)", Kind: ast.ArgCall, Type: "zap.Field"},
+		{Source: "fields", Kind: ast.ArgIdent, Type: "[]zap.Field"},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"arg[0].kind=literal", true},
+		{"arg[0].kind!=literal", false},
+		{"arg[1].type=zap.*", true},
+		{"arg[0].const=true", true},
+		{"arg[1].const=true", false},
+		{`arg[0].source="\"start*"`, true},
+		{"arg[5].kind=literal", false},
+		{"arg[5].kind!=literal", false},
+		{"argc=3", true},
+		{"argc!=3", false},
+		{"args contains zap.Error", true},
+		{"args contains fields", true},
+		{"args contains []zap.Field", true},
+		{"args contains zap.String", false},
+	}
+	for _, tc := range tests {
+		cond, err := ParseCondition(tc.expr)
+		if err != nil {
+			t.Fatalf("ParseCondition(%q) failed: %v", tc.expr, err)
+		}
+		if got := cond.Match(args); got != tc.want {
+			t.Errorf("%q matched %t, want %t", tc.expr, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gwatts/plsdo/pkg/gofiles"
+	"golang.org/x/mod/modfile"
+)
+
+// Module is a module searched for references.
+type Module struct {
+	Path string `json:"path"` // module path, eg. example.com/app
+	Dir  string `json:"dir"`  // absolute path of the directory containing go.mod
+}
+
+// Workspace describes the modules searched for references.
+type Workspace struct {
+	Root    string   // directory containing the files searched for references
+	Work    string   // path of the go.work file in use, if any
+	Modules []Module // the modules of the go.work file, or the main module
+}
+
+// FindWorkspace returns the workspace containing dir, as determined by the go
+// command run with env.  If a go.work file is in use, its directory is the root
+// and each of its modules is searched; otherwise dir itself is the root.
+func FindWorkspace(dir string, env []string) (Workspace, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return Workspace{}, err
+	}
+	cmd := exec.Command("go", "env", "-json", "GOWORK", "GOMOD")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return Workspace{}, fmt.Errorf("go env: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var goenv struct{ GOWORK, GOMOD string }
+	if err := json.Unmarshal(out, &goenv); err != nil {
+		return Workspace{}, fmt.Errorf("go env: %v", err)
+	}
+
+	ws := Workspace{Root: dir}
+	switch {
+	case goenv.GOWORK != "" && goenv.GOWORK != "off":
+		ws.Work = goenv.GOWORK
+		ws.Root = filepath.Dir(goenv.GOWORK)
+		data, err := os.ReadFile(goenv.GOWORK)
+		if err != nil {
+			return Workspace{}, err
+		}
+		work, err := modfile.ParseWork(goenv.GOWORK, data, nil)
+		if err != nil {
+			return Workspace{}, err
+		}
+		for _, use := range work.Use {
+			modDir := filepath.FromSlash(use.Path)
+			if !filepath.IsAbs(modDir) {
+				modDir = filepath.Join(ws.Root, modDir)
+			}
+			mod, err := readModule(modDir)
+			if err != nil {
+				return Workspace{}, err
+			}
+			ws.Modules = append(ws.Modules, mod)
+		}
+	case goenv.GOMOD != "" && goenv.GOMOD != os.DevNull:
+		mod, err := readModule(filepath.Dir(goenv.GOMOD))
+		if err != nil {
+			return Workspace{}, err
+		}
+		ws.Modules = []Module{mod}
+	}
+	return ws, nil
+}
+
+func readModule(dir string) (Module, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return Module{}, err
+	}
+	path := modfile.ModulePath(data)
+	if path == "" {
+		return Module{}, fmt.Errorf("no module path in %s", filepath.Join(dir, "go.mod"))
+	}
+	return Module{Path: path, Dir: dir}, nil
+}
+
+// Dir returns the directory identifying the workspace, which is the same from any
+// of its subdirectories: that of the go.work file in use, or else the root of the
+// main module, or Root outside of a module.
+func (ws Workspace) Dir() string {
+	switch {
+	case ws.Work != "":
+		return filepath.Dir(ws.Work)
+	case len(ws.Modules) == 1:
+		return ws.Modules[0].Dir
+	}
+	return ws.Root
+}
+
+// moduleOf returns the innermost module containing filename.
+func (ws Workspace) moduleOf(filename string) (Module, bool) {
+	var found Module
+	for _, mod := range ws.Modules {
+		if gofiles.Within(mod.Dir, filename) && len(mod.Dir) > len(found.Dir) {
+			found = mod
+		}
+	}
+	return found, found.Dir != ""
+}
+
+// fileRoot returns the directory that filenames within the module in moduleDir
+// are reported relative to: the workspace root if a go.work file is in use, so
+// that files of different modules are distinguished, or else the module's root.
+func (ws Workspace) fileRoot(moduleDir string) string {
+	if ws.Work != "" {
+		return ws.Root
+	}
+	return moduleDir
+}
+
+// multiModule reports whether references may be found in more than one module.
+func (ws Workspace) multiModule() bool {
+	return len(ws.Modules) > 1
+}
+
+// rootRelative returns filename relative to root, with forward slashes, or the
+// absolute filename if it is not within root.
+func rootRelative(root, filename string) string {
+	if gofiles.Within(root, filename) {
+		if rel, err := filepath.Rel(root, filename); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(filename)
+}
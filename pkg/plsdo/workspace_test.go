@@ -0,0 +1,176 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package plsdo
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindWorkspace(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		dir   string   // directory FindWorkspace is run in, relative to the temp dir
+		env   []string // additional environment for the go command
+		root  string   // expected root, relative to the temp dir
+		work  string   // expected go.work, relative to the temp dir
+		mods  []Module // expected modules, with Dir relative to the temp dir
+	}{
+		{
+			name: "single module",
+			files: map[string]string{
+				"app/go.mod": "module example.com/app\n\ngo 1.23\n",
+			},
+			dir:  "app",
+			env:  []string{"GOWORK=off"},
+			root: "app",
+			mods: []Module{{Path: "example.com/app", Dir: "app"}},
+		},
+		{
+			name: "module subdirectory",
+			files: map[string]string{
+				"app/go.mod":     "module example.com/app\n\ngo 1.23\n",
+				"app/sub/sub.go": "package sub\n",
+			},
+			dir:  "app/sub",
+			env:  []string{"GOWORK=off"},
+			root: "app/sub",
+			mods: []Module{{Path: "example.com/app", Dir: "app"}},
+		},
+		{
+			name: "go.work",
+			files: map[string]string{
+				"go.work":        "go 1.23\n\nuse (\n\t./app\n\t./app2\n)\n",
+				"app/go.mod":     "module example.com/app\n\ngo 1.23\n",
+				"app2/go.mod":    "module example.com/app2\n\ngo 1.23\n",
+				"app2/sub/x.txt": "",
+			},
+			dir:  "app2/sub",
+			root: ".",
+			work: "go.work",
+			mods: []Module{{Path: "example.com/app", Dir: "app"}, {Path: "example.com/app2", Dir: "app2"}},
+		},
+		{
+			name:  "no module",
+			files: map[string]string{"src/main.go": "package main\n"},
+			dir:   "src",
+			env:   []string{"GOWORK=off"},
+			root:  "src",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tmp, err := filepath.EvalSymlinks(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, data := range tc.files {
+				path := filepath.Join(tmp, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			env := append([]string{"GOFLAGS=", "GO111MODULE=on"}, tc.env...)
+			ws, err := FindWorkspace(filepath.Join(tmp, filepath.FromSlash(tc.dir)), env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(tmp, filepath.FromSlash(tc.root)); ws.Root != want {
+				t.Errorf("Root = %s, want %s", ws.Root, want)
+			}
+			wantWork := ""
+			if tc.work != "" {
+				wantWork = filepath.Join(tmp, tc.work)
+			}
+			if ws.Work != wantWork {
+				t.Errorf("Work = %q, want %q", ws.Work, wantWork)
+			}
+			var want []Module
+			for _, mod := range tc.mods {
+				want = append(want, Module{Path: mod.Path, Dir: filepath.Join(tmp, filepath.FromSlash(mod.Dir))})
+			}
+			if !reflect.DeepEqual(ws.Modules, want) {
+				t.Errorf("Modules = %+v, want %+v", ws.Modules, want)
+			}
+		})
+	}
+}
+
+func TestModuleOf(t *testing.T) {
+	ws := Workspace{
+		Root: filepath.FromSlash("/repo"),
+		Modules: []Module{
+			{Path: "example.com/app", Dir: filepath.FromSlash("/repo/app")},
+			{Path: "example.com/app/nested", Dir: filepath.FromSlash("/repo/app/nested")},
+			{Path: "example.com/app2", Dir: filepath.FromSlash("/repo/app2")},
+		},
+	}
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"/repo/app/main.go", "example.com/app"},
+		{"/repo/app/nested/x.go", "example.com/app/nested"},
+		{"/repo/app/nestedx/x.go", "example.com/app"},
+		{"/repo/app2/main.go", "example.com/app2"},
+		{"/repo/other/main.go", ""},
+		{"/elsewhere/app/main.go", ""},
+	}
+	for _, tc := range tests {
+		mod, ok := ws.moduleOf(filepath.FromSlash(tc.filename))
+		if mod.Path != tc.want || ok != (tc.want != "") {
+			t.Errorf("moduleOf(%s) = %q, %v, want %q", tc.filename, mod.Path, ok, tc.want)
+		}
+	}
+}
+
+// TestFileRoot checks that files with the same name in different modules of a
+// workspace are reported, counted and grouped separately.
+func TestFileRoot(t *testing.T) {
+	ws := Workspace{
+		Root: filepath.FromSlash("/repo"),
+		Work: filepath.FromSlash("/repo/go.work"),
+		Modules: []Module{
+			{Path: "example.com/a", Dir: filepath.FromSlash("/repo/a")},
+			{Path: "example.com/b", Dir: filepath.FromSlash("/repo/b")},
+		},
+	}
+	m := &Matcher{ws: ws}
+	for _, mod := range ws.Modules {
+		m.refs = append(m.refs, matchEntry{
+			Filename:     filepath.Join(mod.Dir, "util.go"),
+			Line:         1,
+			CallerModule: mod.Path,
+			FileRoot:     ws.fileRoot(mod.Dir),
+		})
+	}
+
+	var files []string
+	for _, me := range m.refs {
+		files = append(files, me.record().File)
+	}
+	if want := []string{"a/util.go", "b/util.go"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("record files = %q, want %q", files, want)
+	}
+
+	stats, err := m.Stats(StatsOptions{By: []string{ByFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stats.Groups[0].Distinct; got != 2 {
+		t.Errorf("stats by file has %d distinct files, want 2", got)
+	}
+
+	// without a go.work file, files are relative to their module
+	single := Workspace{Root: ws.Modules[0].Dir, Modules: ws.Modules[:1]}
+	if got := single.fileRoot(ws.Modules[0].Dir); got != ws.Modules[0].Dir {
+		t.Errorf("fileRoot without go.work = %s, want %s", got, ws.Modules[0].Dir)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gwatts/plsdo/pkg/plsdo"
+)
+
+// Client sends queries to a Server.
+type Client struct {
+	addr string
+	http *http.Client
+	base string
+}
+
+// NewClient creates a Client for a server listening on addr, in the form
+// accepted by Listen.
+func NewClient(addr string) *Client {
+	c := &Client{addr: addr, base: "http://" + addr}
+	transport := &http.Transport{}
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		c.base = "http://plsdo"
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+	}
+	c.http = &http.Client{Transport: transport}
+	return c
+}
+
+// Status returns the status of the server, failing if it is not running.
+func (c *Client) Status() (Status, error) {
+	var status Status
+	resp, err := c.http.Get(c.base + "/status")
+	if err != nil {
+		return status, fmt.Errorf("no server at %s: %v", c.addr, err)
+	}
+	defer resp.Body.Close()
+	return status, decodeResponse(resp, &status)
+}
+
+// Refs writes the references matching req to w, returning the number of
+// references found.
+func (c *Client) Refs(req RefsRequest, w io.Writer) (int, error) {
+	resp, err := c.post("/refs", req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, decodeResponse(resp, nil)
+	}
+	count, _ := strconv.Atoi(resp.Header.Get(countHeader))
+	_, err = io.Copy(w, resp.Body)
+	return count, err
+}
+
+// Defs returns the definitions matching q.
+func (c *Client) Defs(q Query) ([]plsdo.Definition, error) {
+	var defs []plsdo.Definition
+	return defs, c.call("/defs", q, &defs)
+}
+
+// Impls returns the implementations of the definitions matching q.
+func (c *Client) Impls(q Query) ([]plsdo.Implementation, error) {
+	var impls []plsdo.Implementation
+	return impls, c.call("/impls", q, &impls)
+}
+
+// Symbols returns the declarations matching a fuzzy query.
+func (c *Client) Symbols(query string) ([]plsdo.Symbol, error) {
+	var symbols []plsdo.Symbol
+	return symbols, c.call("/symbols", SymbolsRequest{Query: query}, &symbols)
+}
+
+func (c *Client) call(path string, req, v any) error {
+	resp, err := c.post(path, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeResponse(resp, v)
+}
+
+func (c *Client) post(path string, req any) (*http.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Post(c.base+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("no server at %s: %v", c.addr, err)
+	}
+	return resp, nil
+}
+
+// decodeResponse decodes a JSON response into v, or returns the error reported
+// by the server.
+func decodeResponse(resp *http.Response, v any) error {
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("server error: %s", resp.Status)
+		}
+		return fmt.Errorf("server: %s", e.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
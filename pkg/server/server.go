@@ -0,0 +1,434 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+
+// Package server exposes Matcher queries as a JSON API over HTTP, so that a
+// long-running process can answer queries using a warm gopls session.
+//
+// Every endpoint accepts a POST with a JSON request body:
+//
+//	/refs     RefsRequest, returning references in the requested output format
+//	/defs     Query, returning a JSON array of plsdo.Definition
+//	/impls    Query, returning a JSON array of plsdo.Implementation
+//	/symbols  SymbolsRequest, returning a JSON array of plsdo.Symbol
+//
+// GET /status returns a Status.  Errors are returned as a JSON object with an
+// "error" field.
+//
+// Over TCP, only requests addressed to a loopback host such as localhost are
+// answered, and browsers' cross-origin requests are refused, so that a web page
+// can't query the server through DNS rebinding.
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gwatts/plsdo/pkg/ast"
+	"github.com/gwatts/plsdo/pkg/cache"
+	"github.com/gwatts/plsdo/pkg/git"
+	"github.com/gwatts/plsdo/pkg/gopls"
+	"github.com/gwatts/plsdo/pkg/plsdo"
+	"github.com/gwatts/plsdo/pkg/watch"
+)
+
+// countHeader holds the number of references returned by /refs.
+const countHeader = "X-Plsdo-Count"
+
+// Query selects functions and methods by package and pattern.
+type Query struct {
+	Package    string          `json:"package"`  // comma-separated packages or patterns, as accepted by refs
+	Patterns   []string        `json:"patterns"` // function and method globs
+	Visibility ast.Visibility  `json:"visibility"`
+	Build      ast.BuildConfig `json:"build"` // must match the server's build configuration
+}
+
+// RefsRequest finds the references matching a Query, or each of Queries.
+type RefsRequest struct {
+	Query
+	Queries       []plsdo.Query  `json:"queries,omitempty"` // if set, the package and patterns of Query are ignored
+	Instances     []string       `json:"instances,omitempty"`
+	Filter        plsdo.Filter   `json:"filter"`
+	Where         []string       `json:"where,omitempty"` // conditions parsed by plsdo.ParseCondition
+	CallArgs      bool           `json:"call_args"`
+	Statements    bool           `json:"statements"`
+	ContextBefore int            `json:"context_before"`
+	ContextAfter  int            `json:"context_after"`
+	Blame         bool           `json:"blame"`
+	Changes       git.Changes    `json:"changes,omitempty"`  // if set, only report references on these lines
+	Baseline      []plsdo.Record `json:"baseline,omitempty"` // if set, only report references not in the baseline
+
+	Format      string              `json:"format"`             // a registered format; defaults to print
+	Template    string              `json:"template,omitempty"` // overrides Format
+	TemplateAll bool                `json:"template_all"`
+	Style       string              `json:"style"`
+	Color       plsdo.ColorDepth    `json:"color"`
+	Summary     *plsdo.StatsOptions `json:"summary,omitempty"` // if set, output counts rather than references
+	SummaryJson bool                `json:"summary_json"`
+}
+
+// SymbolsRequest finds the declarations matching a fuzzy query.
+type SymbolsRequest struct {
+	Query string `json:"query"`
+}
+
+// Status describes a running server.
+type Status struct {
+	Root    string          `json:"root"`
+	Build   ast.BuildConfig `json:"build"`
+	Pid     int             `json:"pid"`
+	Started time.Time       `json:"started"`
+	Queries int             `json:"queries"`
+}
+
+// Server answers queries using a single Matcher.  Queries are run one at a time.
+type Server struct {
+	mu      sync.Mutex // held while a query is running
+	m       *plsdo.Matcher
+	poller  *watch.Poller
+	status  Status
+	queries atomic.Int64
+	mux     *http.ServeMux
+	Logger  io.Writer // if set, each query is logged
+}
+
+// New creates a Server for the workspace of m.  Files beneath the workspace root
+// are checked for changes before each query, so results reflect the files on
+// disk.
+func New(m *plsdo.Matcher) (*Server, error) {
+	root := m.Workspace().Root
+	poller, err := watch.NewPoller(root, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		m:      m,
+		poller: poller,
+		status: Status{Root: root, Build: m.BuildConfig(), Pid: os.Getpid(), Started: time.Now()},
+		mux:    http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /status", s.handleStatus)
+	s.mux.HandleFunc("POST /refs", s.handleRefs)
+	s.mux.HandleFunc("POST /defs", s.handleDefs)
+	s.mux.HandleFunc("POST /impls", s.handleImpls)
+	s.mux.HandleFunc("POST /symbols", s.handleSymbols)
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Serve accepts connections on l until it is closed.  Requests on a TCP listener
+// must be addressed to a loopback host.
+func (s *Server) Serve(l net.Listener) error {
+	var h http.Handler = s
+	if l.Addr().Network() != "unix" {
+		h = loopbackOnly(s)
+	}
+	err := http.Serve(l, h)
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// loopbackOnly rejects requests unless their Host is a loopback address, and
+// requests from browsers unless their Origin is too.  A page on a host whose DNS
+// record is rebound to 127.0.0.1 still sends its own name as the Host.
+func loopbackOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLoopback(r.Host) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host %q is not a loopback address", r.Host))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || !isLoopback(u.Host) {
+				writeError(w, http.StatusForbidden, fmt.Errorf("requests from origin %q are not accepted", origin))
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isLoopback reports whether host, with an optional port, is localhost or a
+// loopback IP address.
+func isLoopback(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Listen listens on addr, which is either a TCP address such as localhost:7070
+// or a Unix socket path prefixed with unix:.  A stale socket left by a server
+// that is no longer running is removed.
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("a server is already listening on %s", addr)
+		}
+		os.Remove(path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// DefaultAddress returns the Unix socket used by the server for the workspace
+// identified by dir, as returned by plsdo.Workspace.Dir, within the cache directory.
+func DefaultAddress(dir string) (string, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	cacheDir, err := cache.Dir()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256([]byte(root))
+	return "unix:" + filepath.Join(cacheDir, "serve-"+hex.EncodeToString(h[:6])+".sock"), nil
+}
+
+// begin locks the Matcher and prepares it for a new query.
+func (s *Server) begin(build ast.BuildConfig) error {
+	s.mu.Lock()
+	if !reflect.DeepEqual(normalizeBuild(build), normalizeBuild(s.status.Build)) {
+		s.mu.Unlock()
+		return fmt.Errorf("the server was started with build settings %+v, not %+v", s.status.Build, build)
+	}
+	events, err := s.poller.Poll()
+	if err == nil {
+		changes := make([]gopls.FileEvent, len(events))
+		for i, ev := range events {
+			changes[i] = gopls.FileEvent{Filename: ev.Filename, Type: gopls.FileChangeType(ev.Op)}
+		}
+		err = s.m.FilesChanged(changes)
+	}
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.m.Reset()
+	s.queries.Add(1)
+	return nil
+}
+
+func (s *Server) end() {
+	s.mu.Unlock()
+}
+
+func normalizeBuild(bc ast.BuildConfig) ast.BuildConfig {
+	if len(bc.Tags) == 0 {
+		bc.Tags = nil
+	}
+	return bc
+}
+
+func (s *Server) logf(format string, a ...any) {
+	if s.Logger != nil {
+		fmt.Fprintf(s.Logger, time.Now().Format(time.TimeOnly)+" "+format+"\n", a...)
+	}
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := s.status
+	status.Queries = int(s.queries.Load())
+	writeJson(w, status)
+}
+
+func (s *Server) handleRefs(w http.ResponseWriter, r *http.Request) {
+	var req RefsRequest
+	if !readRequest(w, r, &req) {
+		return
+	}
+	if len(req.Queries) > 0 {
+		s.logf("refs %d queries", len(req.Queries))
+	} else {
+		s.logf("refs %s %s", req.Package, strings.Join(req.Patterns, " "))
+	}
+	if err := s.begin(req.Build); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer s.end()
+
+	formatter, err := req.formatter()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	m := s.m
+	m.Visibility = req.Visibility
+	m.Instances = req.Instances
+	m.Filter = req.Filter
+	m.Where = nil
+	for _, expr := range req.Where {
+		cond, err := plsdo.ParseCondition(expr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		m.Where = append(m.Where, cond)
+	}
+	m.CallArgs = req.CallArgs
+	m.Statements = req.Statements
+	m.ContextBefore, m.ContextAfter = req.ContextBefore, req.ContextAfter
+	m.Blame = req.Blame
+	m.Changes = req.Changes
+
+	queries := req.Queries
+	if len(queries) == 0 {
+		queries = []plsdo.Query{{Package: req.Package, Patterns: req.Patterns}}
+	}
+	if err := m.FindQueries(queries...); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if req.Baseline != nil {
+		m.ExcludeBaseline(req.Baseline)
+	}
+
+	// render before writing the headers, so errors can be reported
+	var buf strings.Builder
+	if req.Summary != nil {
+		var stats plsdo.Stats
+		if stats, err = m.Stats(*req.Summary); err == nil {
+			if req.SummaryJson {
+				err = stats.WriteJson(&buf)
+			} else {
+				err = stats.WriteTable(&buf)
+			}
+		}
+	} else {
+		err = formatter.Format(&buf, m, plsdo.FormatOptions{Style: req.Style, Color: req.Color})
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set(countHeader, strconv.Itoa(len(m.Records())))
+	io.WriteString(w, buf.String())
+}
+
+func (req RefsRequest) formatter() (plsdo.Formatter, error) {
+	if req.Template != "" {
+		return plsdo.NewTemplateFormatter(req.Template, req.TemplateAll)
+	}
+	format := req.Format
+	if format == "" {
+		format = "print"
+	}
+	f, ok := plsdo.LookupFormatter(format)
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q; available formats: %s", format, strings.Join(plsdo.Formats(), ", "))
+	}
+	return f, nil
+}
+
+func (s *Server) handleDefs(w http.ResponseWriter, r *http.Request) {
+	var req Query
+	if !readRequest(w, r, &req) {
+		return
+	}
+	s.logf("defs %s %s", req.Package, strings.Join(req.Patterns, " "))
+	if err := s.begin(req.Build); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer s.end()
+
+	s.m.Visibility = req.Visibility
+	defs, err := s.m.FindDefinitions(req.Package, req.Patterns...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJson(w, defs)
+}
+
+func (s *Server) handleImpls(w http.ResponseWriter, r *http.Request) {
+	var req Query
+	if !readRequest(w, r, &req) {
+		return
+	}
+	s.logf("impls %s %s", req.Package, strings.Join(req.Patterns, " "))
+	if err := s.begin(req.Build); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer s.end()
+
+	s.m.Visibility = req.Visibility
+	impls, err := s.m.FindImplementations(req.Package, req.Patterns...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJson(w, impls)
+}
+
+func (s *Server) handleSymbols(w http.ResponseWriter, r *http.Request) {
+	var req SymbolsRequest
+	if !readRequest(w, r, &req) {
+		return
+	}
+	s.logf("symbols %s", req.Query)
+	if err := s.begin(s.status.Build); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer s.end()
+
+	symbols, err := s.m.FindSymbols(req.Query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJson(w, symbols)
+}
+
+func readRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeJson(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
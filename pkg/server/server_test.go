@@ -0,0 +1,44 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoopbackOnly(t *testing.T) {
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		want   int
+	}{
+		{name: "localhost", host: "localhost:7070", want: http.StatusOK},
+		{name: "localhost without port", host: "LOCALHOST", want: http.StatusOK},
+		{name: "ipv4 loopback", host: "127.0.0.1:7070", want: http.StatusOK},
+		{name: "ipv6 loopback", host: "[::1]:7070", want: http.StatusOK},
+		{name: "loopback origin", host: "localhost:7070", origin: "http://localhost:7070", want: http.StatusOK},
+		{name: "rebound name", host: "attacker.example:7070", want: http.StatusForbidden},
+		{name: "external address", host: "192.0.2.1:7070", want: http.StatusForbidden},
+		{name: "cross origin", host: "127.0.0.1:7070", origin: "http://attacker.example", want: http.StatusForbidden},
+		{name: "null origin", host: "127.0.0.1:7070", origin: "null", want: http.StatusForbidden},
+	}
+	h := loopbackOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/status", nil)
+			r.Host = tc.host
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.want {
+				t.Errorf("status = %d, want %d", w.Code, tc.want)
+			}
+		})
+	}
+}
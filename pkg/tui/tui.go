@@ -0,0 +1,359 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+
+// Package tui implements an interactive terminal browser for references.
+package tui
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/gwatts/plsdo/pkg/ast"
+	"github.com/gwatts/plsdo/pkg/plsdo"
+)
+
+const (
+	styleBold    = "\x1b[1m"
+	styleFaint   = "\x1b[2m"
+	styleReverse = "\x1b[7m"
+	styleReset   = "\x1b[0m"
+
+	previewContext = 8 // lines shown either side of the reference in the preview
+	tabWidth       = 4
+)
+
+// Options configures the browser.
+type Options struct {
+	Style  string           // Chroma style used for the preview, or "none"
+	Color  plsdo.ColorDepth // colors supported by the terminal
+	Editor string           // command used to open files; defaults to $VISUAL, $EDITOR or vi
+}
+
+// Run displays records in a navigable list, grouped by file and enclosing
+// function, with a preview of the selected reference.  It returns when the user
+// quits.
+func Run(records []plsdo.Record, opts Options) error {
+	if strings.TrimSpace(opts.Editor) == "" {
+		// a variable set to only whitespace names no command
+		opts.Editor = cmp.Or(strings.TrimSpace(os.Getenv("VISUAL")), strings.TrimSpace(os.Getenv("EDITOR")), "vi")
+	}
+	m := &model{
+		records: records,
+		opts:    opts,
+		sources: make(map[string][]string),
+	}
+	m.applyFilter()
+	_, err := tea.NewProgram(m, tea.WithAltScreen()).Run()
+	return err
+}
+
+type editorDoneMsg struct{ err error }
+
+type model struct {
+	records   []plsdo.Record
+	opts      Options
+	visible   []int // indexes of records matching the filter
+	cursor    int   // index into visible
+	offset    int   // first list row displayed
+	filter    string
+	filtering bool
+	width     int
+	height    int
+	status    string
+	sources   map[string][]string // file lines, by filename
+}
+
+func (m *model) Init() tea.Cmd {
+	return nil
+}
+
+func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+
+	case editorDoneMsg:
+		m.status = ""
+		if msg.err != nil {
+			m.status = "editor: " + msg.err.Error()
+		}
+		// the file may have been edited
+		clear(m.sources)
+
+	case tea.KeyMsg:
+		if m.filtering {
+			return m, m.updateFilter(msg)
+		}
+		switch msg.String() {
+		case "q", "ctrl+c":
+			return m, tea.Quit
+		case "up", "k":
+			m.move(-1)
+		case "down", "j":
+			m.move(1)
+		case "pgup", "ctrl+b":
+			m.move(-m.listHeight())
+		case "pgdown", "ctrl+f", " ":
+			m.move(m.listHeight())
+		case "home", "g":
+			m.move(-len(m.visible))
+		case "end", "G":
+			m.move(len(m.visible))
+		case "/":
+			m.filtering = true
+		case "esc":
+			m.filter = ""
+			m.applyFilter()
+		case "enter", "e", "o":
+			if rec, ok := m.selected(); ok {
+				m.status = "opening " + rec.File
+				return m, m.openEditor(rec)
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m *model) updateFilter(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return tea.Quit
+	case tea.KeyEnter:
+		m.filtering = false
+	case tea.KeyEsc:
+		m.filtering = false
+		m.filter = ""
+	case tea.KeyBackspace:
+		if r := []rune(m.filter); len(r) > 0 {
+			m.filter = string(r[:len(r)-1])
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.filter += string(msg.Runes)
+	default:
+		return nil
+	}
+	m.applyFilter()
+	return nil
+}
+
+// applyFilter selects the records that fuzzy match the filter.
+func (m *model) applyFilter() {
+	m.visible = m.visible[:0]
+	for i, rec := range m.records {
+		if fuzzyMatch(m.filter, rec.File+" "+strings.Join(rec.Enclosing.Chain, " ")+" "+rec.Source.Text) {
+			m.visible = append(m.visible, i)
+		}
+	}
+	m.cursor, m.offset = 0, 0
+}
+
+// fuzzyMatch reports whether the characters of pattern appear in s in order,
+// ignoring case.
+func fuzzyMatch(pattern, s string) bool {
+	s = strings.ToLower(s)
+	for _, r := range strings.ToLower(pattern) {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(string(r)):]
+	}
+	return true
+}
+
+func (m *model) move(n int) {
+	m.cursor = max(0, min(m.cursor+n, len(m.visible)-1))
+}
+
+func (m *model) selected() (plsdo.Record, bool) {
+	if m.cursor >= len(m.visible) {
+		return plsdo.Record{}, false
+	}
+	return m.records[m.visible[m.cursor]], true
+}
+
+// openEditor suspends the browser and opens the record's location in the editor.
+func (m *model) openEditor(rec plsdo.Record) tea.Cmd {
+	args := strings.Fields(m.opts.Editor)
+	filename := cmp.Or(rec.Filename, rec.File)
+	switch filepath.Base(args[0]) {
+	case "code", "code-insiders", "codium", "cursor":
+		args = append(args, "--wait", "--goto", fmt.Sprintf("%s:%d:%d", filename, rec.Line, rec.Column))
+	case "subl", "zed":
+		args = append(args, "--wait", fmt.Sprintf("%s:%d:%d", filename, rec.Line, rec.Column))
+	default:
+		// vi, vim, nvim, emacs, nano, micro, kak, hx...
+		args = append(args, "+"+strconv.Itoa(rec.Line), filename)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return editorDoneMsg{err}
+	})
+}
+
+// listHeight is the number of rows available to the list; the preview takes the rest.
+func (m *model) listHeight() int {
+	return max(3, (m.height-2)*2/5)
+}
+
+// row is a line of the list, either a header or a reference.
+type row struct {
+	text   string
+	cursor int // index into visible, or -1 for headers
+}
+
+func (m *model) rows() []row {
+	var rows []row
+	lastFile, lastEnc := "", ""
+	for i, idx := range m.visible {
+		rec := m.records[idx]
+		if rec.File != lastFile {
+			rows = append(rows, row{text: styleBold + rec.File + styleReset, cursor: -1})
+			lastFile, lastEnc = rec.File, ""
+		}
+		enc := strings.Join(rec.Enclosing.Chain, ast.ChainSeparator)
+		if enc == "" {
+			enc = rec.Enclosing.Function
+		}
+		if enc != lastEnc {
+			rows = append(rows, row{text: "  " + styleFaint + enc + styleReset, cursor: -1})
+			lastEnc = enc
+		}
+		source := strings.Join(strings.Fields(rec.Source.Text), " ")
+		rows = append(rows, row{text: fmt.Sprintf("    %5d  %s", rec.Line, source), cursor: i})
+	}
+	return rows
+}
+
+func (m *model) View() string {
+	if m.width == 0 {
+		return ""
+	}
+	var b strings.Builder
+	width := m.width
+
+	// list
+	rows := m.rows()
+	height := m.listHeight()
+	selectedRow := 0
+	for i, r := range rows {
+		if r.cursor == m.cursor {
+			selectedRow = i
+		}
+	}
+	// keep the selection and its headers in view
+	if selectedRow < m.offset+2 {
+		m.offset = max(0, selectedRow-2)
+	}
+	if selectedRow >= m.offset+height {
+		m.offset = selectedRow - height + 1
+	}
+	for i := m.offset; i < m.offset+height; i++ {
+		if i < len(rows) {
+			line := ansi.Truncate(rows[i].text, width, "…")
+			if rows[i].cursor == m.cursor && rows[i].cursor >= 0 {
+				line = styleReverse + ansi.Strip(line) + strings.Repeat(" ", max(0, width-ansi.StringWidth(line))) + styleReset
+			}
+			b.WriteString(line)
+		}
+		b.WriteString("\n")
+	}
+
+	// status bar
+	status := fmt.Sprintf(" %d/%d references", len(m.visible), len(m.records))
+	if m.filtering || m.filter != "" {
+		status += "  filter: " + m.filter
+		if m.filtering {
+			status += "█"
+		}
+	}
+	if m.status != "" {
+		status += "  " + m.status
+	}
+	help := "↑/↓ move  / filter  enter open  q quit "
+	status += strings.Repeat(" ", max(1, width-ansi.StringWidth(status)-ansi.StringWidth(help))) + help
+	b.WriteString(styleReverse + ansi.Truncate(status, width, "") + styleReset + "\n")
+
+	// preview
+	previewHeight := m.height - height - 2
+	for i, line := range m.preview(previewHeight) {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(ansi.Truncate(line, width, ""))
+	}
+	return b.String()
+}
+
+// preview returns up to height lines of the selected file surrounding the
+// reference, highlighted using the configured style.
+func (m *model) preview(height int) []string {
+	rec, ok := m.selected()
+	if !ok || height <= 0 {
+		return nil
+	}
+	lines, err := m.source(cmp.Or(rec.Filename, rec.File))
+	if err != nil {
+		return []string{err.Error()}
+	}
+	before := min(previewContext, height/2)
+	from := max(1, rec.Line-before)
+	to := min(len(lines), from+height-1)
+	if from > to {
+		return nil
+	}
+
+	// expand tabs, tracking the reference position within the window
+	window := make([]string, 0, to-from+1)
+	refStart, refEnd, offset := -1, -1, 0
+	for n := from; n <= to; n++ {
+		line := lines[n-1]
+		expanded := strings.ReplaceAll(line, "\t", strings.Repeat(" ", tabWidth))
+		if n == rec.Line && rec.Column > 0 && rec.Column <= len(line)+1 {
+			col := rec.Column - 1 + (tabWidth-1)*strings.Count(line[:rec.Column-1], "\t")
+			length := 0
+			if rec.EndLine == rec.Line {
+				length = rec.EndColumn - rec.Column
+			}
+			refStart, refEnd = offset+col, offset+col+length
+		}
+		window = append(window, expanded)
+		offset += len(expanded) + 1
+	}
+
+	highlighted := strings.Split(plsdo.Highlight(strings.Join(window, "\n"), m.opts.Style, m.opts.Color, refStart, refEnd), "\n")
+	result := make([]string, len(highlighted))
+	for i, line := range highlighted {
+		marker := " "
+		if from+i == rec.Line {
+			marker = ">"
+		}
+		result[i] = fmt.Sprintf("%s%5d  %s", marker, from+i, line)
+	}
+	return result
+}
+
+func (m *model) source(filename string) ([]string, error) {
+	if lines, ok := m.sources[filename]; ok {
+		return lines, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(data), "\n")
+	m.sources[filename] = lines
+	return lines, nil
+}
@@ -0,0 +1,193 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+
+// Package watch polls a directory tree for changes to Go source and module files.
+package watch
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gwatts/plsdo/pkg/gofiles"
+)
+
+// Op describes how a file changed.  The values match the LSP FileChangeType.
+type Op int
+
+const (
+	Created Op = 1
+	Changed Op = 2
+	Deleted Op = 3
+)
+
+func (op Op) String() string {
+	switch op {
+	case Created:
+		return "created"
+	case Changed:
+		return "changed"
+	case Deleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// Event is a change to a single file.
+type Event struct {
+	Filename string // absolute path
+	Op       Op
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// Poller detects changes to .go files and go.mod, go.sum, go.work and go.work.sum
+// files beneath a root directory by periodically comparing their size and
+// modification time.  Directories starting with "." or "_" and testdata
+// directories are ignored, as by the go command.
+type Poller struct {
+	root  string
+	files map[string]fileState
+	// Interval is the time between scans by Wait, which must be positive.
+	Interval time.Duration
+}
+
+// NewPoller creates a Poller for the tree rooted at root, recording the current
+// state of its files.
+func NewPoller(root string, interval time.Duration) (*Poller, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	p := &Poller{root: root, Interval: interval}
+	if p.files, err = p.scan(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Poll returns the changes since the previous call, sorted by filename.
+func (p *Poller) Poll() ([]Event, error) {
+	files, err := p.scan()
+	if err != nil {
+		return nil, err
+	}
+	var events []Event
+	for name, state := range files {
+		prev, ok := p.files[name]
+		switch {
+		case !ok:
+			events = append(events, Event{Filename: name, Op: Created})
+		case prev.size != state.size || !prev.modTime.Equal(state.modTime):
+			events = append(events, Event{Filename: name, Op: Changed})
+		}
+	}
+	for name := range p.files {
+		if _, ok := files[name]; !ok {
+			events = append(events, Event{Filename: name, Op: Deleted})
+		}
+	}
+	p.files = files
+	slices.SortFunc(events, func(a, b Event) int { return strings.Compare(a.Filename, b.Filename) })
+	return events, nil
+}
+
+// Wait polls until files change and then stop changing for an interval, so that
+// several files saved together are reported at once.  It returns ctx.Err() if
+// the context is cancelled first.
+func (p *Poller) Wait(ctx context.Context) ([]Event, error) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	var pending []Event
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+		events, err := p.Poll()
+		if err != nil {
+			return nil, err
+		}
+		if len(events) > 0 {
+			pending = append(pending, events...)
+			continue
+		}
+		// files created and then removed again are not reported
+		if merged := merge(pending); len(merged) > 0 {
+			return merged, nil
+		}
+		pending = nil
+	}
+}
+
+// merge combines successive events for the same file into one, based on
+// whether the file existed before the first event and after the last.
+func merge(events []Event) []Event {
+	type span struct{ before, after bool }
+	spans := make(map[string]*span)
+	var names []string
+	for _, ev := range events {
+		sp, ok := spans[ev.Filename]
+		if !ok {
+			sp = &span{before: ev.Op != Created}
+			spans[ev.Filename] = sp
+			names = append(names, ev.Filename)
+		}
+		sp.after = ev.Op != Deleted
+	}
+	slices.Sort(names)
+	var merged []Event
+	for _, name := range names {
+		switch sp := spans[name]; {
+		case sp.before && sp.after:
+			merged = append(merged, Event{Filename: name, Op: Changed})
+		case sp.after:
+			merged = append(merged, Event{Filename: name, Op: Created})
+		case sp.before:
+			merged = append(merged, Event{Filename: name, Op: Deleted})
+		}
+	}
+	return merged
+}
+
+func (p *Poller) scan() (map[string]fileState, error) {
+	files := make(map[string]fileState)
+	err := filepath.WalkDir(p.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != p.root && errors.Is(err, fs.ErrNotExist) {
+				// removed during the walk
+				return nil
+			}
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != p.root && gofiles.IgnoredDir(name) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !gofiles.IsSource(name) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		files[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return files, err
+}
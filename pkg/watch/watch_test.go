@@ -0,0 +1,96 @@
+/*
+Copyright © 2024 Gareth Watts <gareth@omnipotent.net>
+*/
+package watch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event
+		want   string
+	}{
+		{"none", nil, ""},
+		{"single", []Event{{"a.go", Changed}}, "a.go changed"},
+		{"changed repeatedly", []Event{{"a.go", Changed}, {"a.go", Changed}}, "a.go changed"},
+		{"created then changed", []Event{{"a.go", Created}, {"a.go", Changed}}, "a.go created"},
+		{"created then deleted", []Event{{"a.go", Created}, {"a.go", Deleted}}, ""},
+		{"changed then deleted", []Event{{"a.go", Changed}, {"a.go", Deleted}}, "a.go deleted"},
+		{"deleted then recreated", []Event{{"a.go", Deleted}, {"a.go", Created}}, "a.go changed"},
+		{
+			name:   "several files sorted",
+			events: []Event{{"b.go", Created}, {"a.go", Deleted}, {"c.go", Changed}, {"b.go", Changed}},
+			want:   "a.go deleted, b.go created, c.go changed",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, ev := range merge(tc.events) {
+				got = append(got, fmt.Sprintf("%s %s", ev.Filename, ev.Op))
+			}
+			if s := strings.Join(got, ", "); s != tc.want {
+				t.Errorf("merge = %q, want %q", s, tc.want)
+			}
+		})
+	}
+}
+
+func TestPoll(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.go", "package a\n")
+	write("go.mod", "module example.com/a\n")
+
+	p, err := NewPoller(root, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("a.go", "package a\n\nfunc A() {}\n")
+	write("sub/b.go", "package sub\n")
+	write("go.mod", "module example.com/a\n\ngo 1.23\n")
+	write("README.md", "ignored")
+	write("testdata/c.go", "package ignored\n")
+	write(".hidden/d.go", "package ignored\n")
+
+	events, err := p.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ev := range events {
+		rel, _ := filepath.Rel(root, ev.Filename)
+		got = append(got, fmt.Sprintf("%s %s", filepath.ToSlash(rel), ev.Op))
+	}
+	if s, want := strings.Join(got, ", "), "a.go changed, go.mod changed, sub/b.go created"; s != want {
+		t.Errorf("Poll = %q, want %q", s, want)
+	}
+
+	if err := os.Remove(filepath.Join(root, "sub/b.go")); err != nil {
+		t.Fatal(err)
+	}
+	events, err = p.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Op != Deleted {
+		t.Errorf("Poll after removal = %v, want one deletion", events)
+	}
+}